@@ -26,6 +26,7 @@ class ChatCli {
     this.socket = io(this.endpoint, {
       path: '/socket.io/',
       extraHeaders: this.sessionCookie ? { Cookie: this.sessionCookie } : {},
+      auth: { clientType: 'cli', room: discussionId || '' },
       transports: ['websocket'],
     });
 
@@ -142,7 +143,7 @@ class ChatCli {
   }
 
   setTyping(isTyping) {
-    this.socket.emit('typing', { isTyping });
+    this.socket.emit('typing', { isTyping, room: this.discussionId || '' });
   }
 
   bindSocketEvents() {
@@ -191,6 +192,8 @@ class ChatCli {
     });
 
     this.socket.on('typing', (data) => {
+      // Typing is scoped to the open discussion
+      if (data.room && data.room !== this.discussionId) return;
       if (data.users) {
         this.updateTypingStatus(data.users);
       }
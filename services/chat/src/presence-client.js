@@ -1,5 +1,6 @@
 import * as grpc from '@grpc/grpc-js';
 import * as protoLoader from '@grpc/proto-loader';
+import { readFileSync } from 'fs';
 import { resolve, dirname } from 'path';
 import { fileURLToPath } from 'url';
 
@@ -20,37 +21,134 @@ const packageDef = protoLoader.loadSync(PROTO_PATH, {
 });
 const proto = grpc.loadPackageDefinition(packageDef).presence;
 
+/**
+ * mTLS when PRESENCE_TLS_CA is set: the CA verifies the presence server, and
+ * PRESENCE_TLS_CERT / PRESENCE_TLS_KEY identify this service to it.
+ */
+function credentials() {
+  const { PRESENCE_TLS_CA, PRESENCE_TLS_CERT, PRESENCE_TLS_KEY } = process.env;
+  if (!PRESENCE_TLS_CA) return grpc.credentials.createInsecure();
+  return grpc.credentials.createSsl(
+    readFileSync(PRESENCE_TLS_CA),
+    PRESENCE_TLS_KEY ? readFileSync(PRESENCE_TLS_KEY) : null,
+    PRESENCE_TLS_CERT ? readFileSync(PRESENCE_TLS_CERT) : null,
+  );
+}
+
+/** PRESENCE_API_KEY identifies this service when it has no client certificate. */
+function callMetadata() {
+  const metadata = new grpc.Metadata();
+  if (process.env.PRESENCE_API_KEY) {
+    metadata.set('x-api-key', process.env.PRESENCE_API_KEY);
+  }
+  return metadata;
+}
+
 export class PresenceClient {
   constructor({ host = process.env.PRESENCE_HOST || 'localhost:50051' } = {}) {
-    this.client = new proto.PresenceService(
-      host,
-      grpc.credentials.createInsecure(),
-    );
+    this.client = new proto.PresenceService(host, credentials());
+    this.metadata = callMetadata();
   }
 
-  userConnected(username) {
-    return this._call('userConnected', { username });
+  /**
+   * Returns a client whose calls carry an end user's session token, for
+   * presence servers that check writes against the session store.
+   */
+  withSession(token) {
+    const scoped = Object.create(this);
+    scoped.metadata = this.metadata.clone();
+    if (token) scoped.metadata.set('x-session-token', token);
+    return scoped;
   }
 
-  userDisconnected(username) {
-    return this._call('userDisconnected', { username });
+  userConnected(
+    username,
+    { room = '', connectionId = '', node = '', clientType = '' } = {},
+  ) {
+    return this._call('userConnected', {
+      username,
+      room,
+      connectionId,
+      node,
+      clientType,
+    });
+  }
+
+  userDisconnected(username, connectionId = '') {
+    return this._call('userDisconnected', { username, connectionId });
   }
 
-  setTyping(username, isTyping) {
-    return this._call('setTyping', { username, isTyping });
+  heartbeat(username, connectionId) {
+    return this._call('heartbeat', { username, connectionId });
   }
 
-  getOnlineUsers() {
-    return this._call('getOnlineUsers', {});
+  registerNode(node) {
+    return this._call('registerNode', { node });
+  }
+
+  purgeNode(node) {
+    return this._call('purgeNode', { node });
+  }
+
+  getLastSeen(usernames) {
+    return this._call('getLastSeen', { usernames });
+  }
+
+  getPresence(usernames, viewer = '') {
+    return this._call('getPresence', { usernames, viewer });
+  }
+
+  getUserConnections(username) {
+    return this._call('getUserConnections', { username });
+  }
+
+  setTyping(username, isTyping, room = '') {
+    return this._call('setTyping', { username, isTyping, room });
+  }
+
+  joinRoom(username, room) {
+    return this._call('joinRoom', { username, room });
+  }
+
+  leaveRoom(username, room) {
+    return this._call('leaveRoom', { username, room });
+  }
+
+  /** status is one of ONLINE, AWAY, BUSY, INVISIBLE; expiresAt is unix ms. */
+  setStatus(username, { status, customText = '', emoji = '', expiresAt = 0 }) {
+    return this._call('setStatus', {
+      username,
+      status,
+      customText,
+      emoji,
+      expiresAt,
+    });
+  }
+
+  getOnlineUsers(viewer = '') {
+    return this._call('getOnlineUsers', { viewer });
   }
 
   getTypingUsers() {
     return this._call('getTypingUsers', {});
   }
 
+  getRoomOnlineUsers(room) {
+    return this._call('getRoomOnlineUsers', { room });
+  }
+
+  getRoomTypingUsers(room) {
+    return this._call('getRoomTypingUsers', { room });
+  }
+
+  /** Server stream: a SNAPSHOT event followed by incremental changes. */
+  watchPresence(room = '') {
+    return this.client.watchPresence({ room }, this.metadata);
+  }
+
   _call(method, req) {
     return new Promise((resolve, reject) => {
-      this.client[method](req, (err, res) => {
+      this.client[method](req, this.metadata, (err, res) => {
         if (err) reject(err);
         else resolve(res);
       });
@@ -1,13 +1,35 @@
 import fastify from 'fastify';
 import { Server as SocketIOServer } from 'socket.io';
+import * as grpc from '@grpc/grpc-js';
 import { PresenceClient } from './presence-client.js';
 
+// Presence leases last 30s; renew well before they run out.
+const HEARTBEAT_INTERVAL_MS = 10000;
+// Tags this pod's connections so presence can drop them if the pod dies.
+const NODE = process.env.HOSTNAME || '';
+// Session cookie set by the accounts service.
+const SESSION_COOKIE = 'auth_token';
+
 /**
  * @typedef {{ text: string }} MessageData
  * @typedef {{ from: string, data: MessageData, timestamp: string }} MessagePayload
  * @typedef {{ message: string, timestamp: string }} WelcomePayload
  */
 
+/**
+ * The session token of a socket's user, from the session cookie the gateway
+ * checked, so presence can verify writes are made for that user.
+ * @param {import('socket.io').Socket} socket
+ */
+function sessionToken(socket) {
+  const cookies = socket.handshake.headers.cookie || '';
+  for (const pair of cookies.split(';')) {
+    const [name, ...value] = pair.trim().split('=');
+    if (name === SESSION_COOKIE) return decodeURIComponent(value.join('='));
+  }
+  return '';
+}
+
 class Server {
   constructor({ port = process.env.PORT || 3000 } = {}) {
     this.port = port;
@@ -22,27 +44,6 @@ class Server {
     this.app.get('/health', async () => {
       return { status: 'ok', service: 'chat' };
     });
-
-    // Presence proxy endpoints
-    this.app.get('/presence/online', async () => {
-      try {
-        const { usernames } = await this.presence.getOnlineUsers();
-        return { online: usernames || [] };
-      } catch (err) {
-        this.app.log.warn(`presence proxy failed: ${err.message}`);
-        return { error: 'presence_service_unavailable' };
-      }
-    });
-
-    this.app.get('/presence/typing', async () => {
-      try {
-        const { usernames } = await this.presence.getTypingUsers();
-        return { typing: usernames || [] };
-      } catch (err) {
-        this.app.log.warn(`presence proxy failed: ${err.message}`);
-        return { error: 'presence_service_unavailable' };
-      }
-    });
   }
 
   setupSocketIO() {
@@ -64,6 +65,11 @@ class Server {
       }
 
       socket.username = username;
+      socket.room =
+        typeof socket.handshake.auth.room === 'string'
+          ? socket.handshake.auth.room
+          : '';
+      socket.presence = this.presence.withSession(sessionToken(socket));
       next();
     });
 
@@ -80,33 +86,78 @@ class Server {
     });
 
     try {
-      const { usernames } = await this.presence.userConnected(socket.username);
-      this.io.emit('presence', { online: usernames });
+      await this.registerPresence(socket);
+      await this.broadcastPresence();
     } catch (err) {
       this.app.log.warn(`presence.userConnected failed: ${err.message}`);
     }
 
+    // Renew the presence lease; re-register if it already expired.
+    const heartbeat = setInterval(async () => {
+      try {
+        await socket.presence.heartbeat(socket.username, socket.id);
+      } catch (err) {
+        if (err.code !== grpc.status.NOT_FOUND) {
+          this.app.log.warn(`presence.heartbeat failed: ${err.message}`);
+          return;
+        }
+        await this.registerPresence(socket).catch((e) =>
+          this.app.log.warn(`presence.userConnected failed: ${e.message}`),
+        );
+      }
+    }, HEARTBEAT_INTERVAL_MS);
+
     socket.on('message', (data) => this.onMessage(socket, data));
 
+    socket.on('join', async (data) => {
+      const room = typeof data?.room === 'string' ? data.room : '';
+      if (room === socket.room) return;
+      const previous = socket.room;
+      socket.room = room;
+      try {
+        await this.leaveRoom(socket, previous);
+        if (room) await socket.presence.joinRoom(socket.username, room);
+      } catch (err) {
+        this.app.log.warn(`presence.joinRoom failed: ${err.message}`);
+      }
+    });
+
     socket.on('typing', async (data) => {
+      const room = typeof data?.room === 'string' ? data.room : '';
       try {
-        await this.presence.setTyping(socket.username, !!data?.isTyping);
-        const { usernames } = await this.presence.getTypingUsers();
-        socket.broadcast.emit('typing', { users: usernames });
+        await socket.presence.setTyping(socket.username, !!data?.isTyping, room);
+        const { usernames } = room
+          ? await this.presence.getRoomTypingUsers(room)
+          : await this.presence.getTypingUsers();
+        socket.broadcast.emit('typing', { room, users: usernames || [] });
       } catch (err) {
         this.app.log.warn(`presence.setTyping failed: ${err.message}`);
       }
     });
 
+    socket.on('status', async (data) => {
+      try {
+        await socket.presence.setStatus(socket.username, {
+          status: data?.status || 'ONLINE',
+          customText: data?.customText || '',
+          emoji: data?.emoji || '',
+          expiresAt: data?.expiresAt || 0,
+        });
+        await this.broadcastPresence();
+      } catch (err) {
+        this.app.log.warn(`presence.setStatus failed: ${err.message}`);
+      }
+    });
+
     socket.on('disconnect', async () => {
+      clearInterval(heartbeat);
       this.app.log.info(`User ${socket.username} disconnected`);
       try {
-        await this.presence.userDisconnected(socket.username);
-        const [{ usernames: online }, { usernames: typing }] = await Promise.all([
-          this.presence.getOnlineUsers(),
+        await socket.presence.userDisconnected(socket.username, socket.id);
+        const [, { usernames: typing }] = await Promise.all([
+          this.broadcastPresence(),
           this.presence.getTypingUsers(),
         ]);
-        this.io.emit('presence', { online });
         this.io.emit('typing', { users: typing || [] });
       } catch (err) {
         this.app.log.warn(`presence.userDisconnected failed: ${err.message}`);
@@ -114,6 +165,38 @@ class Server {
     });
   }
 
+  /** Sends everyone the online users with their statuses, as seen by others. */
+  async broadcastPresence() {
+    const { usernames, users } = await this.presence.getOnlineUsers();
+    this.io.emit('presence', { online: usernames || [], users: users || [] });
+  }
+
+  /**
+   * Registers the socket's connection, in the discussion it has open.
+   * @param {import('socket.io').Socket} socket
+   */
+  registerPresence(socket) {
+    return socket.presence.userConnected(socket.username, {
+      room: socket.room,
+      connectionId: socket.id,
+      node: NODE,
+      clientType: socket.handshake.auth.clientType || 'web',
+    });
+  }
+
+  /**
+   * Takes the socket's user out of a discussion they switched away from,
+   * unless another of their sockets on this pod still has it open.
+   * @param {import('socket.io').Socket} socket @param {string} room
+   */
+  async leaveRoom(socket, room) {
+    if (!room) return;
+    for (const other of this.io.of('/').sockets.values()) {
+      if (other.username === socket.username && other.room === room) return;
+    }
+    await socket.presence.leaveRoom(socket.username, room);
+  }
+
   /** @param {import('socket.io').Socket} socket @param {MessageData} data */
   onMessage(socket, data) {
     if (!data?.text || typeof data.text !== 'string') return;
@@ -131,9 +214,31 @@ class Server {
     socket.broadcast.emit('message', payload);
   }
 
+  /** Keeps this pod registered with presence, and purges it on shutdown. */
+  registerNode() {
+    if (!NODE) return;
+    const renew = () =>
+      this.presence.registerNode(NODE).catch((err) =>
+        this.app.log.warn(`presence.registerNode failed: ${err.message}`),
+      );
+    renew();
+    const timer = setInterval(renew, HEARTBEAT_INTERVAL_MS);
+
+    process.once('SIGTERM', async () => {
+      clearInterval(timer);
+      try {
+        await this.presence.purgeNode(NODE);
+      } catch (err) {
+        this.app.log.warn(`presence.purgeNode failed: ${err.message}`);
+      }
+      process.exit(0);
+    });
+  }
+
   async start() {
     await this.app.listen({ port: this.port, host: '0.0.0.0' });
     this.setupSocketIO();
+    this.registerNode();
     this.app.log.info(`Fastify server listening on port ${this.port}`);
     this.app.log.info(
       `Socket.io server ready on ws://localhost:${this.port}/socket.io/`,
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer implements the PresenceAdmin gRPC interface. It only uses the
+// backend's ordinary operations, so its changes publish the same transitions
+// as the RPCs chat makes. Every call is written to audit once it is done.
+type adminServer struct {
+	pb.UnimplementedPresenceAdminServer
+	store backend
+	audit *slog.Logger
+}
+
+// record writes a call to the audit log, with the caller the context carries.
+func (a *adminServer) record(ctx context.Context, err error, attrs ...any) {
+	method, _ := grpc.Method(ctx)
+	attrs = append([]any{"method", method}, attrs...)
+	if err != nil {
+		a.audit.WarnContext(ctx, "admin call failed", append(attrs, "error", err)...)
+		return
+	}
+	a.audit.InfoContext(ctx, "admin call", attrs...)
+}
+
+func (a *adminServer) DumpState(ctx context.Context, _ *pb.Empty) (*pb.StateDump, error) {
+	d, err := a.store.dump()
+	a.record(ctx, err, "connections", len(d.connections), "typing", len(d.typing))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.StateDump{DumpedAt: time.Now().UnixMilli()}
+	for _, c := range d.connections {
+		resp.Connections = append(resp.Connections, connectionToProto(c))
+	}
+	for k, at := range d.typing {
+		resp.Typing = append(resp.Typing, &pb.TypingState{Username: k.username, Room: k.room, Since: at.UnixMilli()})
+	}
+	slices.SortFunc(resp.Typing, func(a, b *pb.TypingState) int {
+		return cmp.Or(strings.Compare(a.Room, b.Room), strings.Compare(a.Username, b.Username))
+	})
+	for _, st := range d.statuses {
+		resp.Statuses = append(resp.Statuses, statusToProto(st))
+	}
+	for room, members := range d.rooms {
+		resp.Rooms = append(resp.Rooms, &pb.RoomMembers{Room: room, Usernames: members})
+	}
+	slices.SortFunc(resp.Rooms, func(a, b *pb.RoomMembers) int { return strings.Compare(a.Room, b.Room) })
+	for node, expiresAt := range d.nodes {
+		resp.Nodes = append(resp.Nodes, &pb.ChatNode{Node: node, ExpiresAt: expiresAt.UnixMilli()})
+	}
+	slices.SortFunc(resp.Nodes, func(a, b *pb.ChatNode) int { return strings.Compare(a.Node, b.Node) })
+	return resp, nil
+}
+
+func (a *adminServer) DisconnectUser(ctx context.Context, req *pb.AdminUserRequest) (*pb.AdminResponse, error) {
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	n, err := a.disconnectAll(req.Username)
+	a.record(ctx, err, "username", req.Username, "connections", n)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.AdminResponse{Affected: int32(n)}, nil
+}
+
+// disconnectAll drops every connection of username and returns how many it
+// dropped. It drops none if any is held by a cluster peer.
+func (a *adminServer) disconnectAll(username string) (int, error) {
+	conns, err := a.store.userConnections(username)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range conns {
+		if err := ownConnection(c); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, c := range conns {
+		if err := a.store.disconnect(username, c.id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ownConnection refuses a connection gossiped by a cluster peer: dropping it
+// here would only last until the peer's next gossip round.
+func ownConnection(c connection) error {
+	if c.origin != "" {
+		return fmt.Errorf("%w (%s): disconnect %s through that replica", errRemoteConnection, c.origin, c.id)
+	}
+	return nil
+}
+
+func (a *adminServer) DisconnectConnection(ctx context.Context, req *pb.AdminConnectionRequest) (*pb.AdminResponse, error) {
+	if req.ConnectionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "connection_id is required")
+	}
+	c, err := a.store.connection(req.ConnectionId)
+	if err == nil {
+		err = ownConnection(c)
+	}
+	if err != nil {
+		a.record(ctx, err, "connection_id", req.ConnectionId)
+		return nil, grpcError(err)
+	}
+	err = a.store.disconnect(c.username, c.id)
+	a.record(ctx, err, "connection_id", c.id, "username", c.username)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.AdminResponse{Affected: 1}, nil
+}
+
+func (a *adminServer) ClearTyping(ctx context.Context, req *pb.ClearTypingRequest) (*pb.AdminResponse, error) {
+	attrs := []any{"username", req.Username}
+	if req.Room != nil {
+		attrs = append(attrs, "room", *req.Room)
+	}
+	d, err := a.store.dump()
+	n := 0
+	if err == nil {
+		for k := range d.typing {
+			if req.Username != "" && k.username != req.Username || req.Room != nil && k.room != *req.Room {
+				continue
+			}
+			if err = a.store.setTyping(k.username, k.room, false); err != nil {
+				break
+			}
+			n++
+		}
+	}
+	a.record(ctx, err, append(attrs, "cleared", n)...)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.AdminResponse{Affected: int32(n)}, nil
+}
+
+func (a *adminServer) ResetUser(ctx context.Context, req *pb.AdminUserRequest) (*pb.AdminResponse, error) {
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	// Dropping the last connection also stops the user's typing and takes
+	// them out of their rooms.
+	n, err := a.disconnectAll(req.Username)
+	if err == nil {
+		_, err = a.store.setStatus(userStatus{username: req.Username, status: statusOnline})
+	}
+	a.record(ctx, err, "username", req.Username, "connections", n)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.AdminResponse{Affected: int32(n)}, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/alicebob/miniredis/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// serveAdmin serves both services on store behind roles for the API keys
+// "chat-key" (writer) and "ops-key" (admin), with reflection, and returns a
+// connection to it.
+func serveAdmin(t *testing.T, store backend, audit *bytes.Buffer) *grpc.ClientConn {
+	t.Helper()
+	a := newAuthorizer(&authPolicy{
+		enforce: true,
+		roles:   map[string]role{"chat": roleWriter, "ops": roleAdmin},
+		apiKeys: map[[32]byte]string{sha256.Sum256([]byte("chat-key")): "chat", sha256.Sum256([]byte("ops-key")): "ops"},
+	}, slog.New(contextHandler{slog.NewJSONHandler(audit, nil)}))
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(identityUnaryInterceptor, a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(identityStreamInterceptor, a.streamInterceptor),
+	)
+	pb.RegisterPresenceServiceServer(srv, &server{store: store})
+	pb.RegisterPresenceAdminServer(srv, &adminServer{store: store, audit: a.audit})
+	reflection.Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.GracefulStop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestAdmin(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		s := newStore(defaultTunables())
+		defer s.stopCleanup()
+		testAdmin(t, s)
+	})
+	t.Run("redis", func(t *testing.T) {
+		testAdmin(t, newTestRedisStore(t, miniredis.RunT(t)))
+	})
+}
+
+func testAdmin(t *testing.T, store backend) {
+	var audit bytes.Buffer
+	conn := serveAdmin(t, store, &audit)
+	client, admin := pb.NewPresenceServiceClient(conn), pb.NewPresenceAdminClient(conn)
+	chat := metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, "chat-key")
+	ops := metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, "ops-key")
+	must := func(_ any, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(client.UserConnected(chat, &pb.UserRequest{Username: "alice", ConnectionId: "a1", Room: "r1", Node: "chat-0"}))
+	must(client.UserConnected(chat, &pb.UserRequest{Username: "alice", ConnectionId: "a2"}))
+	must(client.UserConnected(chat, &pb.UserRequest{Username: "bob", ConnectionId: "b1"}))
+	must(client.RegisterNode(chat, &pb.NodeRequest{Node: "chat-0"}))
+	must(client.SetTyping(chat, &pb.SetTypingRequest{Username: "alice", Room: "r1", IsTyping: true}))
+	must(client.SetTyping(chat, &pb.SetTypingRequest{Username: "bob", IsTyping: true}))
+	must(client.SetStatus(chat, &pb.SetStatusRequest{Username: "bob", Status: pb.Status_BUSY}))
+
+	if _, err := admin.DumpState(chat, &pb.Empty{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("writer dumps state: expected PermissionDenied, got %v", err)
+	}
+	dump, err := admin.DumpState(ops, &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, c := range dump.Connections {
+		ids = append(ids, c.Id)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"a1", "a2", "b1"}) {
+		t.Errorf("connections = %v, want a1 a2 b1", ids)
+	}
+	if len(dump.Typing) != 2 || dump.Typing[0].Username != "bob" || dump.Typing[1].Room != "r1" || dump.Typing[1].Since == 0 {
+		t.Errorf("typing = %v, want bob globally and alice in r1", dump.Typing)
+	}
+	if len(dump.Statuses) != 1 || dump.Statuses[0].Status != pb.Status_BUSY {
+		t.Errorf("statuses = %v, want bob busy", dump.Statuses)
+	}
+	if len(dump.Rooms) != 1 || !slices.Equal(dump.Rooms[0].Usernames, []string{"alice"}) {
+		t.Errorf("rooms = %v, want alice in r1", dump.Rooms)
+	}
+	if len(dump.Nodes) != 1 || dump.Nodes[0].Node != "chat-0" {
+		t.Errorf("nodes = %v, want chat-0", dump.Nodes)
+	}
+
+	resp, err := admin.ClearTyping(ops, &pb.ClearTypingRequest{Room: proto.String("r1")})
+	if err != nil || resp.Affected != 1 {
+		t.Fatalf("clear typing in r1: %v, %v", resp, err)
+	}
+	typing, _ := client.GetTypingUsers(chat, &pb.Empty{})
+	if !slices.Equal(typing.Usernames, []string{"bob"}) {
+		t.Errorf("typing after clearing r1 = %v, want bob", typing.Usernames)
+	}
+
+	if resp, err = admin.DisconnectConnection(ops, &pb.AdminConnectionRequest{ConnectionId: "a1"}); err != nil || resp.Affected != 1 {
+		t.Fatalf("disconnect a1: %v, %v", resp, err)
+	}
+	if _, err = admin.DisconnectConnection(ops, &pb.AdminConnectionRequest{ConnectionId: "a1"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("disconnect a1 again: expected NotFound, got %v", err)
+	}
+	if resp, err = admin.DisconnectUser(ops, &pb.AdminUserRequest{Username: "alice"}); err != nil || resp.Affected != 1 {
+		t.Fatalf("disconnect alice: %v, %v", resp, err)
+	}
+	if resp, err = admin.ResetUser(ops, &pb.AdminUserRequest{Username: "bob"}); err != nil || resp.Affected != 1 {
+		t.Fatalf("reset bob: %v, %v", resp, err)
+	}
+	if dump, err = admin.DumpState(ops, &pb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Connections)+len(dump.Typing)+len(dump.Statuses)+len(dump.Rooms) != 0 {
+		t.Errorf("state left after resetting everyone: %v", dump)
+	}
+
+	for _, want := range []string{
+		`"msg":"admin call","method":"/presence.PresenceAdmin/ResetUser","username":"bob","connections":1,"caller":"ops"`,
+		`"msg":"admin call failed","method":"/presence.PresenceAdmin/DisconnectConnection","connection_id":"a1"`,
+		`"msg":"rpc denied"`,
+	} {
+		if !strings.Contains(audit.String(), want) {
+			t.Errorf("audit log lacks %s:\n%s", want, audit.String())
+		}
+	}
+
+	// Reflection lists the services for grpcurl.
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(chat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}})
+	list, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services []string
+	for _, s := range list.GetListServicesResponse().GetService() {
+		services = append(services, s.Name)
+	}
+	if !slices.Contains(services, "presence.PresenceAdmin") {
+		t.Errorf("reflection lists %v, without presence.PresenceAdmin", services)
+	}
+}
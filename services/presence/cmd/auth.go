@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeyHeader is the metadata key clients without a certificate send their API key in.
+const apiKeyHeader = "x-api-key"
+
+// role is what a caller may do. Each role includes the ones below it.
+type role int
+
+const (
+	roleNone role = iota
+	roleReader
+	roleWriter
+	roleAdmin
+)
+
+var roleNames = map[role]string{roleNone: "none", roleReader: "reader", roleWriter: "writer", roleAdmin: "admin"}
+
+func (r role) String() string { return roleNames[r] }
+
+func parseRole(s string) (role, error) {
+	for r, name := range roleNames {
+		if s == name {
+			return r, nil
+		}
+	}
+	return roleNone, fmt.Errorf("unknown role %q", s)
+}
+
+// methodRoles is the role each RPC needs. Health checks are open to everyone,
+// so probes keep working; any method not listed, such as admin RPCs, needs
+// roleAdmin.
+var methodRoles = map[string]role{
+	pb.PresenceService_UserConnected_FullMethodName:                        roleWriter,
+	pb.PresenceService_UserDisconnected_FullMethodName:                     roleWriter,
+	pb.PresenceService_Heartbeat_FullMethodName:                            roleWriter,
+	pb.PresenceService_SetTyping_FullMethodName:                            roleWriter,
+	pb.PresenceService_SetStatus_FullMethodName:                            roleWriter,
+	pb.PresenceService_JoinRoom_FullMethodName:                             roleWriter,
+	pb.PresenceService_LeaveRoom_FullMethodName:                            roleWriter,
+	pb.PresenceService_RegisterNode_FullMethodName:                         roleWriter,
+	pb.PresenceService_PurgeNode_FullMethodName:                            roleWriter,
+	pb.PresenceService_GetOnlineUsers_FullMethodName:                       roleReader,
+	pb.PresenceService_GetTypingUsers_FullMethodName:                       roleReader,
+	pb.PresenceService_GetUserConnections_FullMethodName:                   roleReader,
+	pb.PresenceService_GetLastSeen_FullMethodName:                          roleReader,
+	pb.PresenceService_GetPresence_FullMethodName:                          roleReader,
+	pb.PresenceService_GetRoomOnlineUsers_FullMethodName:                   roleReader,
+	pb.PresenceService_GetRoomTypingUsers_FullMethodName:                   roleReader,
+	pb.PresenceService_WatchPresence_FullMethodName:                        roleReader,
+	pb.PresenceCluster_Gossip_FullMethodName:                               roleWriter,
+	pb.PresenceAdmin_DumpState_FullMethodName:                              roleAdmin,
+	pb.PresenceAdmin_DisconnectUser_FullMethodName:                         roleAdmin,
+	pb.PresenceAdmin_DisconnectConnection_FullMethodName:                   roleAdmin,
+	pb.PresenceAdmin_ClearTyping_FullMethodName:                            roleAdmin,
+	pb.PresenceAdmin_ResetUser_FullMethodName:                              roleAdmin,
+	reflectionpb.ServerReflection_ServerReflectionInfo_FullMethodName:      roleReader,
+	reflectionv1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: roleReader,
+	healthpb.Health_Check_FullMethodName:                                   roleNone,
+	healthpb.Health_Watch_FullMethodName:                                   roleNone,
+	healthpb.Health_List_FullMethodName:                                    roleNone,
+}
+
+func requiredRole(method string) role {
+	if r, ok := methodRoles[method]; ok {
+		return r
+	}
+	return roleAdmin
+}
+
+// authPolicy maps caller identities to roles.
+type authPolicy struct {
+	enforce   bool                // false lets every call through but admin RPCs
+	roles     map[string]role     // by caller name
+	apiKeys   map[[32]byte]string // caller name by SHA-256 of the key
+	anonymous role                // role of callers with neither a certificate nor an API key
+}
+
+// roleOf returns the role granted to c, or to an anonymous caller if c is nil.
+func (p *authPolicy) roleOf(c *caller) role {
+	if c == nil {
+		return p.anonymous
+	}
+	return p.roles[c.name]
+}
+
+// authorizer identifies callers that send an API key instead of a client
+// certificate, and rejects calls the caller's role does not allow. Denials are
+// written to the audit log. The policy can be swapped while serving.
+type authorizer struct {
+	policy atomic.Pointer[authPolicy]
+	audit  *slog.Logger
+}
+
+func newAuthorizer(p *authPolicy, audit *slog.Logger) *authorizer {
+	a := &authorizer{audit: audit}
+	a.policy.Store(p)
+	return a
+}
+
+// authorize returns ctx with the caller attached, or the status error to fail the call with.
+func (a *authorizer) authorize(ctx context.Context, method string) (context.Context, error) {
+	p := a.policy.Load()
+	var who *caller
+	if c, ok := callerFrom(ctx); ok {
+		who = &c
+	} else if key := metadata.ValueFromIncomingContext(ctx, apiKeyHeader); len(key) > 0 {
+		name, ok := p.apiKeys[sha256.Sum256([]byte(key[0]))]
+		if !ok {
+			a.deny(ctx, method, "unknown API key")
+			return ctx, status.Error(codes.Unauthenticated, "unknown API key")
+		}
+		c := caller{name: name, source: "api-key"}
+		ctx, who = context.WithValue(ctx, callerKey{}, c), &c
+	}
+	need := requiredRole(method)
+	if !p.enforce {
+		// Nobody can hold the admin role without a policy.
+		if need == roleAdmin {
+			a.deny(ctx, method, "no admin role configured")
+			return ctx, status.Errorf(codes.PermissionDenied, "%s needs role admin, and no role policy is configured", method)
+		}
+		return ctx, nil
+	}
+	have := p.roleOf(who)
+	if have < need {
+		a.deny(ctx, method, "needs role "+need.String(), "role", have.String())
+		return ctx, status.Errorf(codes.PermissionDenied, "%s needs role %s", method, need)
+	}
+	return ctx, nil
+}
+
+func (a *authorizer) deny(ctx context.Context, method, reason string, attrs ...any) {
+	attrs = append([]any{"method", method, "reason", reason}, attrs...)
+	if c, ok := callerFrom(ctx); ok {
+		attrs = append(attrs, "subject", c.subject, "source", c.source)
+	} else {
+		attrs = append(attrs, "caller", "anonymous")
+	}
+	a.audit.WarnContext(ctx, "rpc denied", attrs...)
+}
+
+// unaryInterceptor runs after identityUnaryInterceptor.
+func (a *authorizer) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamInterceptor runs after identityStreamInterceptor.
+func (a *authorizer) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// loadAPIKeys reads a file of "name=key" lines. Blank lines and lines starting
+// with # are skipped.
+func loadAPIKeys(path string) (map[[32]byte]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys := map[[32]byte]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, key, ok := strings.Cut(line, "=")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected name=key", path, n)
+		}
+		keys[sha256.Sum256([]byte(key))] = name
+	}
+	return keys, sc.Err()
+}
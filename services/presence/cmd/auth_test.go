@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorization(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-keys")
+	writeFile(t, path, []byte("# consumers\nchat=chat-key\ndashboard = dashboard-key\n"), time.Now())
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audit bytes.Buffer
+	a := newAuthorizer(&authPolicy{
+		enforce: true,
+		roles:   map[string]role{"chat": roleWriter, "dashboard": roleReader},
+		apiKeys: keys,
+	}, slog.New(contextHandler{slog.NewJSONHandler(&audit, nil)}))
+	client := serveBackend(t, newStore(defaultTunables()),
+		grpc.ChainUnaryInterceptor(identityUnaryInterceptor, a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(identityStreamInterceptor, a.streamInterceptor),
+	)
+	as := func(key string) context.Context {
+		if key == "" {
+			return context.Background()
+		}
+		return metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, key)
+	}
+	check := func(what string, err error, want codes.Code) {
+		t.Helper()
+		if status.Code(err) != want {
+			t.Fatalf("%s: expected %v, got %v", what, want, err)
+		}
+	}
+
+	_, err = client.UserConnected(as("chat-key"), &pb.UserRequest{Username: "alice"})
+	check("chat connects alice", err, codes.OK)
+	_, err = client.GetOnlineUsers(as("dashboard-key"), &pb.OnlineUsersRequest{})
+	check("dashboard reads", err, codes.OK)
+	_, err = client.UserDisconnected(as("dashboard-key"), &pb.UserRequest{Username: "alice"})
+	check("dashboard writes", err, codes.PermissionDenied)
+	_, err = client.GetOnlineUsers(as("stolen-key"), &pb.OnlineUsersRequest{})
+	check("unknown key", err, codes.Unauthenticated)
+	stream, err := client.WatchPresence(as(""), &pb.WatchPresenceRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	check("anonymous watch", err, codes.PermissionDenied)
+
+	logged := strings.Count(audit.String(), `"msg":"rpc denied"`)
+	if logged != 3 || !strings.Contains(audit.String(), `"caller":"dashboard"`) {
+		t.Fatalf("expected 3 denials in the audit log, got:\n%s", audit.String())
+	}
+}
+
+func TestAuthorizationOff(t *testing.T) {
+	var audit bytes.Buffer
+	a := newAuthorizer(&authPolicy{roles: map[string]role{}}, slog.New(contextHandler{slog.NewJSONHandler(&audit, nil)}))
+	ctx := context.Background()
+	if _, err := a.authorize(ctx, pb.PresenceService_UserDisconnected_FullMethodName); err != nil {
+		t.Fatalf("expected writes open without a policy, got %v", err)
+	}
+	for _, method := range []string{pb.PresenceAdmin_DumpState_FullMethodName, pb.PresenceAdmin_DisconnectUser_FullMethodName} {
+		if _, err := a.authorize(ctx, method); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected %s closed without a policy, got %v", method, err)
+		}
+	}
+	if !strings.Contains(audit.String(), `"reason":"no admin role configured"`) {
+		t.Fatalf("expected the denial in the audit log, got:\n%s", audit.String())
+	}
+}
+
+func TestSessionValidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Set("session:alice-token", "alice")
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	v := newSessionValidator(rdb, "session:", 100*time.Millisecond, slog.New(slog.DiscardHandler))
+	client := serveBackend(t, newStore(defaultTunables()), grpc.ChainUnaryInterceptor(v.unaryInterceptor))
+	session := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), sessionHeader, token)
+	}
+
+	if _, err := client.UserConnected(session("alice-token"), &pb.UserRequest{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UserConnected(session("alice-token"), &pb.UserRequest{Username: "bob"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected alice's session to be refused for bob, got %v", err)
+	}
+	if _, err := client.SetTyping(context.Background(), &pb.SetTypingRequest{Username: "alice", IsTyping: true}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a write without a session to be refused, got %v", err)
+	}
+	if _, err := client.GetOnlineUsers(context.Background(), &pb.OnlineUsersRequest{}); err != nil {
+		t.Fatalf("expected reads to need no session, got %v", err)
+	}
+
+	// A revoked session is honoured until the cached lookup expires
+	mr.Del("session:alice-token")
+	if _, err := client.SetTyping(session("alice-token"), &pb.SetTypingRequest{Username: "alice", IsTyping: true}); err != nil {
+		t.Fatalf("expected the cached session to be accepted, got %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := client.SetTyping(session("alice-token"), &pb.SetTypingRequest{Username: "alice"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the revoked session to be refused, got %v", err)
+	}
+}
+
+func TestSessionEndDisconnects(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Set("session:laptop", "alice")
+	mr.Set("session:phone", "alice")
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	v := newSessionValidator(rdb, "session:", time.Minute, slog.New(slog.DiscardHandler))
+	s := newStore(defaultTunables())
+	t.Cleanup(s.stopCleanup)
+	client := serveBackend(t, s, grpc.ChainUnaryInterceptor(v.unaryInterceptor))
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go v.watch(s, done)
+
+	for token, id := range map[string]string{"laptop": "s1", "phone": "s2"} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), sessionHeader, token)
+		if _, err := client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Logging out of the laptop drops only its connection. Miniredis has no
+	// keyspace notifications, so send the one Redis would.
+	mr.Del("session:laptop")
+	eventually(t, "expected the laptop's connection to be dropped", func() bool {
+		mr.Publish("__keyspace@0__:session:laptop", "del")
+		conns, _ := s.userConnections("alice")
+		return len(conns) == 1 && conns[0].id == "s2"
+	})
+	mr.Publish("__keyspace@0__:session:phone", "expired")
+	eventually(t, "expected alice offline once her last session expired", func() bool {
+		users, _ := s.onlineUsers("")
+		return len(users) == 0
+	})
+}
+
+func TestSessionBindingsPruned(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Set("session:laptop", "alice")
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	v := newSessionValidator(rdb, "session:", time.Minute, slog.New(slog.DiscardHandler))
+	s := newStore(defaultTunables())
+	t.Cleanup(s.stopCleanup)
+	client := serveBackend(t, s, grpc.ChainUnaryInterceptor(v.unaryInterceptor))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), sessionHeader, "laptop")
+	for _, id := range []string{"s1", "s2"} {
+		if _, err := client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// s1 ends without a UserDisconnected, e.g. its lease ran out
+	s.disconnect("alice", "s1")
+	if err := v.pruneBindings(s); err != nil {
+		t.Fatal(err)
+	}
+	if conns := v.bindings["laptop"].conns; len(conns) != 1 {
+		t.Fatalf("expected only s2 still bound, got %v", conns)
+	}
+	s.disconnect("alice", "s2")
+	v.pruneBindings(s)
+	if len(v.bindings) != 0 {
+		t.Fatalf("expected the session's binding gone, got %v", v.bindings)
+	}
+}
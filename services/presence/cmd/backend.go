@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	errNotOnline          = errors.New("user is not online")
+	errConnectionConflict = errors.New("connection ID belongs to another user")
+	errUnknownConnection  = errors.New("unknown or expired connection")
+	errRemoteConnection   = errors.New("connection is held by another replica")
+)
+
+// tunables are the timings a backend runs with, set from the store section of
+// the config.
+type tunables struct {
+	// leaseTTL is how long a connection stays online without a heartbeat, and
+	// a chat node stays registered without renewing.
+	leaseTTL time.Duration
+	// typingTimeout is how long a typing status lasts without a refresh.
+	typingTimeout time.Duration
+	// sweepInterval is how often expired typing, leases and statuses are removed.
+	sweepInterval time.Duration
+}
+
+func defaultTunables() tunables {
+	return tunables{
+		leaseTTL:      30 * time.Second,
+		typingTimeout: 8 * time.Second,
+		sweepInterval: time.Second,
+	}
+}
+
+// backend holds presence state for server. Every implementation applies the
+// same rules and publishes the same transitions to watchers, so clients cannot
+// tell them apart: store keeps state in memory for a single replica, and
+// redisStore shares it between replicas through Redis.
+type backend interface {
+	// Mutations.
+	connect(c connection, room string) ([]string, error)
+	disconnect(username, connID string) error
+	heartbeat(username, connID string) (time.Time, error)
+	joinRoom(username, room string) ([]string, error)
+	leaveRoom(username, room string) error
+	setTyping(username, room string, isTyping bool) error
+	setStatus(st userStatus) (userStatus, error)
+	registerNode(node string) (time.Time, error)
+	purgeNode(node string) (int, error)
+
+	// Queries.
+	onlineUsers(viewer string) ([]string, error)
+	roomOnlineUsers(room, viewer string) ([]string, error)
+	typingUsers(viewer string) ([]string, error)
+	roomTypingUsers(room, viewer string) ([]string, error)
+	userStatuses(usernames []string) ([]userStatus, error)
+	userConnections(username string) ([]connection, error)
+	connection(connID string) (connection, error)
+	lastSeenUsers(usernames []string) ([]seen, error)
+	presence(usernames []string, viewer string) ([]userPresence, error)
+	watch(room, viewer string) (snapshot, <-chan event, func(), error)
+	resume(room, viewer string, after uint64) (<-chan event, func(), bool)
+	// goAway ends every watch, present and future, with a going-away event.
+	goAway()
+	stats() (storeStats, error)
+	dump() (stateDump, error)
+
+	// setTypingTimeout changes the typing timeout of a running backend.
+	setTypingTimeout(d time.Duration)
+
+	// Expiry. Implementations run these on a background sweep until stopCleanup.
+	cleanupExpiredTyping() error
+	reapExpiredLeases() error
+	expireStatuses() error
+	reapExpiredNodes() error
+	stopCleanup()
+}
+
+// typingKey scopes a typing status to a room. An empty room is the global scope.
+type typingKey struct {
+	room     string
+	username string
+}
+
+// connection is a single client socket registered by a chat node.
+type connection struct {
+	id          string
+	username    string
+	node        string // chat pod owning the socket
+	clientType  string
+	connectedAt time.Time
+	expiresAt   time.Time // lease deadline; zero for anonymous connections
+	anonymous   bool      // registered without a caller-supplied ID
+	provisional bool      // restored from a snapshot and not yet confirmed
+	origin      string    // cluster peer that gossiped it; "" if registered through this replica
+}
+
+// seen is when a user was last online; at is zero if they never were.
+type seen struct {
+	username string
+	online   bool
+	at       time.Time
+}
+
+// userPresence is everything known about one user's presence.
+type userPresence struct {
+	username    string
+	online      bool
+	status      userStatus
+	connections int
+	typingRooms []string // "" for global typing
+	lastSeen    time.Time
+}
+
+// stateDump is the whole presence state, for the admin service.
+type stateDump struct {
+	connections []connection            // oldest first
+	typing      map[typingKey]time.Time // last refresh
+	statuses    []userStatus            // non-default only
+	rooms       map[string][]string     // room -> sorted online members
+	nodes       map[string]time.Time    // chat node -> lease deadline
+}
+
+// runSweep calls sweep every interval until done is closed.
+func runSweep(interval time.Duration, done <-chan struct{}, sweep func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sweep()
+		case <-done:
+			return
+		}
+	}
+}
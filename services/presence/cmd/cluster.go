@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// gossipFanout is how many peers each round talks to.
+	gossipFanout = 3
+	// deadReplicaRetention is how long a timed-out replica is remembered, so
+	// stale copies of its state still circulating cannot bring it back.
+	deadReplicaRetention = time.Hour
+)
+
+// cluster replicates presence between replicas without an external store.
+// Every replica owns what was registered through it and gossips that to a few
+// random peers each round, together with the freshest state it has heard from
+// everyone else. Each replica merges what it hears into its own store as state
+// held by the replica it came from, so every query and watcher sees the merged
+// view. The view is eventually consistent: a change reaches every replica
+// within a few rounds, and a replica that stops gossiping is dropped, with the
+// usual offline transitions, once peerTimeout passes.
+type cluster struct {
+	pb.UnimplementedPresenceClusterServer
+	store       *store
+	self        string // advertised address, also this replica's ID
+	incarnation int64
+	seeds       []string
+	interval    time.Duration
+	peerTimeout time.Duration
+	creds       credentials.TransportCredentials // for dialing peers
+
+	mu       sync.Mutex // guards everything below and orders merges into store
+	version  uint64
+	replicas map[string]*replica // address -> freshest state heard
+	clients  map[string]*grpc.ClientConn
+
+	done chan struct{}
+}
+
+// replica is the last state heard from one peer.
+type replica struct {
+	state   *pb.ReplicaState
+	heardAt time.Time // when its version last advanced
+	dead    bool
+}
+
+// replicaState is what one replica holds through its own connections.
+type replicaState struct {
+	conns  []connection
+	rooms  map[string][]string // room -> members
+	typing map[typingKey]time.Time
+}
+
+// statusUpdate is a status change stamped for last-writer-wins merging.
+type statusUpdate struct {
+	status    userStatus
+	updatedAt time.Time
+}
+
+// newCluster creates a cluster member advertised as self that finds its peers
+// through seeds. Seeds may include self, and a DNS name resolving to several
+// replicas works as a single seed: whoever answers tells us about the rest.
+// Peers are dialed with creds.
+func newCluster(s *store, self string, seeds []string, interval, peerTimeout time.Duration, creds credentials.TransportCredentials) *cluster {
+	return &cluster{
+		store:       s,
+		self:        self,
+		incarnation: time.Now().UnixNano(),
+		seeds:       seeds,
+		interval:    interval,
+		peerTimeout: peerTimeout,
+		creds:       creds,
+		replicas:    make(map[string]*replica),
+		clients:     make(map[string]*grpc.ClientConn),
+		done:        make(chan struct{}),
+	}
+}
+
+// start gossips every interval until stop.
+func (c *cluster) start() {
+	go runSweep(c.interval, c.done, c.round)
+}
+
+// stop stops gossiping. Peers drop this replica's state once it times out.
+func (c *cluster) stop() {
+	close(c.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.clients {
+		conn.Close()
+	}
+}
+
+// Gossip merges a peer's message and replies with everything known here.
+func (c *cluster) Gossip(_ context.Context, msg *pb.GossipMessage) (*pb.GossipMessage, error) {
+	c.merge(msg)
+	return c.message(), nil
+}
+
+// round gossips with a few random peers, then drops peers that went quiet.
+func (c *cluster) round() {
+	c.mu.Lock()
+	c.version++
+	c.mu.Unlock()
+
+	msg := c.message()
+	var wg sync.WaitGroup
+	for _, client := range c.targets() {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+			defer cancel()
+			resp, err := client.Gossip(ctx, msg)
+			if err != nil {
+				slog.Debug("gossip failed", "error", err)
+				return
+			}
+			c.merge(resp)
+		})
+	}
+	wg.Wait()
+	c.expire()
+}
+
+// targets picks up to gossipFanout peers among the seeds and known replicas.
+func (c *cluster) targets() []pb.PresenceClusterClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addrs := slices.Clone(c.seeds)
+	for addr, r := range c.replicas {
+		if !r.dead {
+			addrs = append(addrs, addr)
+		}
+	}
+	slices.Sort(addrs)
+	addrs = slices.DeleteFunc(slices.Compact(addrs), func(addr string) bool { return addr == c.self })
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+
+	var clients []pb.PresenceClusterClient
+	for _, addr := range addrs[:min(gossipFanout, len(addrs))] {
+		conn, ok := c.clients[addr]
+		if !ok {
+			var err error
+			conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(c.creds))
+			if err != nil {
+				slog.Warn("invalid peer address", "peer", addr, "error", err)
+				continue
+			}
+			c.clients[addr] = conn
+		}
+		clients = append(clients, pb.NewPresenceClusterClient(conn))
+	}
+	return clients
+}
+
+// message returns this replica's own state, the live state of every peer heard
+// from and every known status change.
+func (c *cluster) message() *pb.GossipMessage {
+	own := replicaToProto(c.store.localState())
+	msg := &pb.GossipMessage{}
+	for _, u := range c.store.statusUpdates() {
+		msg.Statuses = append(msg.Statuses, &pb.StatusUpdate{Status: statusToProto(u.status), UpdatedAt: u.updatedAt.UnixNano()})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	own.Addr, own.Incarnation, own.Version = c.self, c.incarnation, c.version
+	msg.Replicas = append(msg.Replicas, own)
+	for _, r := range c.replicas {
+		if !r.dead {
+			msg.Replicas = append(msg.Replicas, r.state)
+		}
+	}
+	return msg
+}
+
+// merge applies every replica state newer than the one known here.
+func (c *cluster) merge(msg *pb.GossipMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, rs := range msg.Replicas {
+		if rs.Addr == c.self {
+			continue
+		}
+		r, ok := c.replicas[rs.Addr]
+		if ok && (rs.Incarnation < r.state.Incarnation || rs.Incarnation == r.state.Incarnation && rs.Version <= r.state.Version) {
+			continue
+		}
+		if !ok || r.dead {
+			slog.Info("replica joined", "replica", rs.Addr)
+		}
+		c.replicas[rs.Addr] = &replica{state: rs, heardAt: now}
+		c.store.mergeReplica(rs.Addr, replicaFromProto(rs))
+	}
+
+	updates := make([]statusUpdate, len(msg.Statuses))
+	for i, u := range msg.Statuses {
+		updates[i] = statusUpdate{status: statusFromProto(u.Status), updatedAt: time.Unix(0, u.UpdatedAt)}
+	}
+	c.store.mergeStatuses(updates)
+}
+
+// expire drops the state of replicas whose version stopped advancing.
+func (c *cluster) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for addr, r := range c.replicas {
+		switch {
+		case !r.dead && now.Sub(r.heardAt) > c.peerTimeout:
+			slog.Warn("replica timed out", "replica", addr, "connections", len(r.state.Connections))
+			r.dead = true
+			r.state = &pb.ReplicaState{Addr: addr, Incarnation: r.state.Incarnation, Version: r.state.Version}
+			c.store.dropReplica(addr)
+		case r.dead && now.Sub(r.heardAt) > deadReplicaRetention:
+			delete(c.replicas, addr)
+		}
+	}
+}
+
+func replicaToProto(state replicaState) *pb.ReplicaState {
+	rs := &pb.ReplicaState{}
+	for _, conn := range state.conns {
+		rs.Connections = append(rs.Connections, connectionToProto(conn))
+	}
+	for room, members := range state.rooms {
+		rs.Rooms = append(rs.Rooms, &pb.RoomMembers{Room: room, Usernames: members})
+	}
+	for k, at := range state.typing {
+		rs.Typing = append(rs.Typing, &pb.TypingState{Username: k.username, Room: k.room, Since: at.UnixMilli()})
+	}
+	return rs
+}
+
+func replicaFromProto(rs *pb.ReplicaState) replicaState {
+	state := replicaState{
+		rooms:  make(map[string][]string, len(rs.Rooms)),
+		typing: make(map[typingKey]time.Time, len(rs.Typing)),
+	}
+	for _, pc := range rs.Connections {
+		state.conns = append(state.conns, connection{
+			id:          pc.Id,
+			username:    pc.Username,
+			node:        pc.Node,
+			clientType:  pc.ClientType,
+			connectedAt: time.UnixMilli(pc.ConnectedAt),
+			anonymous:   pc.Anonymous,
+			provisional: pc.Provisional,
+		})
+	}
+	for _, rm := range rs.Rooms {
+		state.rooms[rm.Room] = rm.Usernames
+	}
+	for _, t := range rs.Typing {
+		state.typing[typingKey{room: t.Room, username: t.Username}] = time.UnixMilli(t.Since)
+	}
+	return state
+}
+
+func statusFromProto(ps *pb.UserStatus) userStatus {
+	st := userStatus{
+		username: ps.GetUsername(),
+		status:   presenceStatus(ps.GetStatus()),
+		text:     ps.GetCustomText(),
+		emoji:    ps.GetEmoji(),
+	}
+	if ps.GetExpiresAt() > 0 {
+		st.expiresAt = time.UnixMilli(ps.GetExpiresAt())
+	}
+	return st
+}
+
+// localState returns the connections, room memberships and typing statuses
+// registered through this replica.
+func (s *store) localState() replicaState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := replicaState{
+		rooms:  make(map[string][]string),
+		typing: make(map[typingKey]time.Time),
+	}
+	for _, c := range s.conns {
+		if c.origin == "" {
+			state.conns = append(state.conns, *c)
+		}
+	}
+	for room, members := range s.rooms {
+		for u, from := range members {
+			if _, ok := from[""]; ok {
+				state.rooms[room] = append(state.rooms[room], u)
+			}
+		}
+	}
+	for k, at := range s.typing {
+		if _, remote := s.typingOrigin[k]; !remote {
+			state.typing[k] = at
+		}
+	}
+	return state
+}
+
+// statusUpdates returns the latest status change of every user known here,
+// including resets to plain online, so they win over older changes elsewhere.
+func (s *store) statusUpdates() []statusUpdate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	updates := make([]statusUpdate, 0, len(s.statusSetAt))
+	for u, at := range s.statusSetAt {
+		updates = append(updates, statusUpdate{status: s.statusLocked(u), updatedAt: at})
+	}
+	return updates
+}
+
+// mergeStatuses applies status changes newer than the ones known here.
+func (s *store) mergeStatuses(updates []statusUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, u := range updates {
+		username := u.status.username
+		if !u.updatedAt.After(s.statusSetAt[username]) {
+			continue
+		}
+		s.setStatusLocked(u.status, now)
+		s.statusSetAt[username] = u.updatedAt
+	}
+}
+
+// mergeReplica replaces everything held through origin with state, publishing
+// the transitions between the two exactly as local changes would. A connection
+// ID already held by another replica stays with it.
+func (s *store) mergeReplica(origin string, state replicaState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	held := make(map[string]bool, len(state.conns))
+	for _, c := range state.conns {
+		if c.anonymous {
+			c.id = origin + "/" + c.id // generated IDs are only unique per replica
+		}
+		held[c.id] = true
+		if s.connClaims[c.id] == nil {
+			s.connClaims[c.id] = make(origins)
+		}
+		s.connClaims[c.id][origin] = struct{}{}
+		if _, ok := s.conns[c.id]; ok {
+			continue
+		}
+		c.origin = origin
+		c.expiresAt = time.Time{} // lives as long as origin keeps gossiping it
+		s.addConnLocked(&c, now)
+	}
+	for id, from := range s.connClaims {
+		if _, ok := from[origin]; ok && !held[id] {
+			delete(from, origin)
+			if len(from) == 0 {
+				delete(s.connClaims, id)
+			}
+		}
+	}
+	for _, c := range s.conns {
+		if c.origin != origin || held[c.id] {
+			continue
+		}
+		if to, ok := s.claimantLocked(c.id); ok {
+			s.transferLocked(c, to, now)
+		} else {
+			s.removeConnLocked(c, now)
+		}
+	}
+
+	// Only online users can be in rooms. Memberships held through a replica
+	// are dropped with the user's last connection through it.
+	for room, members := range state.rooms {
+		for _, u := range members {
+			if _, ok := s.online[u]; ok {
+				s.joinRoomLocked(u, room, origin, now)
+			}
+		}
+	}
+	for room, members := range s.rooms {
+		for u, from := range members {
+			if _, ok := from[origin]; ok && !slices.Contains(state.rooms[room], u) {
+				s.leaveRoomLocked(u, room, origin, now)
+			}
+		}
+	}
+
+	// Typing that already expired here is not revived by a slower peer.
+	cutoff := now.Add(-s.typingTimeout)
+	for k, at := range state.typing {
+		_, exists := s.typing[k]
+		if exists && s.typingOrigin[k] != origin || at.Before(cutoff) {
+			continue
+		}
+		s.typing[k] = at
+		s.typingOrigin[k] = origin
+		if !exists {
+			s.publishLocked(eventTypingStarted, k.username, k.room, now)
+		}
+	}
+	for k, from := range s.typingOrigin {
+		if _, ok := state.typing[k]; ok || from != origin {
+			continue
+		}
+		delete(s.typingOrigin, k)
+		if _, ok := s.typing[k]; ok {
+			delete(s.typing, k)
+			s.publishLocked(eventTypingStopped, k.username, k.room, now)
+		}
+	}
+}
+
+// dropReplica removes everything held through origin, as if all its
+// connections had disconnected.
+func (s *store) dropReplica(origin string) {
+	s.mergeReplica(origin, replicaState{})
+}
+
+// claimantLocked returns a peer, other than the connection's current holder,
+// that gossips it too: a replica it moved to. Caller must hold s.mu.
+func (s *store) claimantLocked(connID string) (string, bool) {
+	holder := s.conns[connID].origin
+	for origin := range s.connClaims[connID] {
+		if origin != holder {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// transferLocked hands a connection over to the replica it moved to, without
+// any transition. If it was the user's last connection through its previous
+// replica, the rooms they joined through it are now held through the new one.
+// Caller must hold s.mu.
+func (s *store) transferLocked(c *connection, to string, now time.Time) {
+	from := c.origin
+	c.origin = to
+	c.expiresAt = time.Time{}
+	for _, uc := range s.online[c.username] {
+		if uc.origin == from {
+			return
+		}
+	}
+	for room, members := range s.rooms {
+		if held, ok := members[c.username][from]; ok {
+			members[c.username][to] = held
+			s.leaveRoomLocked(c.username, room, from, now)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// testReplica is one cluster member serving gossip on localhost.
+type testReplica struct {
+	store   *store
+	cluster *cluster
+	srv     *grpc.Server
+}
+
+func (r *testReplica) stop() {
+	r.cluster.stop()
+	r.srv.Stop()
+	r.store.stopCleanup()
+}
+
+// startCluster starts n replicas that all list each other as seeds.
+func startCluster(t *testing.T, n int) []*testReplica {
+	t.Helper()
+	lis := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range n {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis[i], addrs[i] = l, l.Addr().String()
+	}
+
+	replicas := make([]*testReplica, n)
+	for i := range n {
+		s := newStore(defaultTunables())
+		c := newCluster(s, addrs[i], addrs, 20*time.Millisecond, 300*time.Millisecond, insecure.NewCredentials())
+		srv := grpc.NewServer()
+		pb.RegisterPresenceClusterServer(srv, c)
+		go srv.Serve(lis[i])
+		c.start()
+		r := &testReplica{store: s, cluster: c, srv: srv}
+		replicas[i] = r
+		t.Cleanup(func() {
+			if !isClosed(c.done) {
+				r.stop()
+			}
+		})
+	}
+	return replicas
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventually fails the test unless cond becomes true within a few seconds.
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClusterMergedView(t *testing.T) {
+	r := startCluster(t, 3)
+
+	r[0].store.connect(connection{id: "s1", username: "alice"}, "d1")
+	r[1].store.connect(connection{username: "bob"}, "")
+	eventually(t, "expected every replica to see alice and bob", func() bool {
+		for _, rep := range r {
+			if users, _ := rep.store.onlineUsers(""); !slices.Equal(users, []string{"alice", "bob"}) {
+				return false
+			}
+		}
+		return true
+	})
+	if users, _ := r[2].store.roomOnlineUsers("d1", ""); !slices.Equal(users, []string{"alice"}) {
+		t.Fatalf("expected alice in d1 on replica 2, got %v", users)
+	}
+	conns, _ := r[2].store.userConnections("alice")
+	if len(conns) != 1 || conns[0].id != "s1" || conns[0].origin != r[0].cluster.self {
+		t.Fatalf("expected s1 held by replica 0, got %+v", conns)
+	}
+
+	r[0].store.setTyping("alice", "d1", true)
+	r[1].store.setStatus(userStatus{username: "bob", status: statusInvisible})
+	eventually(t, "expected typing and status to spread", func() bool {
+		typing, _ := r[2].store.roomTypingUsers("d1", "")
+		users, _ := r[2].store.onlineUsers("")
+		return slices.Equal(typing, []string{"alice"}) && slices.Equal(users, []string{"alice"})
+	})
+
+	r[0].store.disconnect("alice", "s1")
+	eventually(t, "expected alice offline everywhere", func() bool {
+		for _, rep := range r {
+			if users, _ := rep.store.onlineUsers("bob"); !slices.Equal(users, []string{"bob"}) {
+				return false
+			}
+		}
+		typing, _ := r[2].store.typingUsers("")
+		return len(typing) == 0
+	})
+}
+
+func TestClusterReplicaFailure(t *testing.T) {
+	r := startCluster(t, 3)
+
+	r[0].store.connect(connection{id: "s1", username: "alice"}, "")
+	r[0].store.connect(connection{id: "s2", username: "bob"}, "d1")
+	eventually(t, "expected replica 1 to see alice and bob", func() bool {
+		users, _ := r[1].store.roomOnlineUsers("d1", "")
+		return slices.Equal(users, []string{"bob"})
+	})
+
+	// bob's chat node moves to replica 1, which takes over his connection
+	if _, err := r[1].store.heartbeat("bob", "s2"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, events, cancel, _ := r[2].store.watch("", "")
+	defer cancel()
+	r[0].stop()
+
+	eventually(t, "expected alice dropped with replica 0", func() bool {
+		users, _ := r[2].store.onlineUsers("")
+		return slices.Equal(users, []string{"bob"})
+	})
+	if users, _ := r[2].store.roomOnlineUsers("d1", ""); !slices.Equal(users, []string{"bob"}) {
+		t.Fatalf("expected bob still in d1, got %v", users)
+	}
+	for ev := range events {
+		if ev.typ == eventOffline {
+			if ev.username != "alice" {
+				t.Fatalf("expected only alice to go offline, got %+v", ev)
+			}
+			break
+		}
+	}
+}
+
+func TestClusterAdminDisconnect(t *testing.T) {
+	r := startCluster(t, 2)
+	ctx := context.Background()
+	admin := func(rep *testReplica) *adminServer {
+		return &adminServer{store: rep.store, audit: slog.New(slog.DiscardHandler)}
+	}
+
+	r[0].store.connect(connection{id: "s1", username: "alice"}, "")
+	eventually(t, "expected replica 1 to see alice", func() bool {
+		conns, _ := r[1].store.userConnections("alice")
+		return len(conns) == 1
+	})
+
+	// Replica 0 would gossip alice's connection straight back, so replica 1
+	// refuses to drop it
+	if _, err := admin(r[1]).DisconnectConnection(ctx, &pb.AdminConnectionRequest{ConnectionId: "s1"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+	for _, call := range []func(context.Context, *pb.AdminUserRequest) (*pb.AdminResponse, error){admin(r[1]).DisconnectUser, admin(r[1]).ResetUser} {
+		if _, err := call(ctx, &pb.AdminUserRequest{Username: "alice"}); status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got %v", err)
+		}
+	}
+	if users, _ := r[1].store.onlineUsers(""); len(users) != 1 {
+		t.Fatalf("expected alice still online, got %v", users)
+	}
+
+	res, err := admin(r[0]).DisconnectUser(ctx, &pb.AdminUserRequest{Username: "alice"})
+	if err != nil || res.Affected != 1 {
+		t.Fatalf("expected replica 0 to drop alice's connection, got %v, %v", res, err)
+	}
+	eventually(t, "expected alice offline on replica 1", func() bool {
+		users, _ := r[1].store.onlineUsers("")
+		return len(users) == 0
+	})
+}
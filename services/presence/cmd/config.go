@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is every setting of the presence server. Each source overrides the
+// previous one: the defaults, the YAML or JSON file named by -config or
+// CONFIG_FILE, environment variables, then flags.
+type config struct {
+	Listen    listenConfig    `json:"listen"`
+	Log       logConfig       `json:"log"`
+	Store     storeConfig     `json:"store"`
+	Redis     redisConfig     `json:"redis"`
+	TLS       tlsConfig       `json:"tls"`
+	Cluster   clusterConfig   `json:"cluster"`
+	Auth      authConfig      `json:"auth"`
+	Sessions  sessionsConfig  `json:"sessions"`
+	RateLimit rateLimitConfig `json:"rate_limit"`
+	Tracing   tracingConfig   `json:"tracing"`
+	Reload    reloadConfig    `json:"reload"`
+	Shutdown  shutdownConfig  `json:"shutdown"`
+
+	file string // the file it was read from, if any
+}
+
+type listenConfig struct {
+	GRPC    string `json:"grpc"`    // gRPC listen address
+	HTTP    string `json:"http"`    // HTTP/JSON listen address, the gRPC one to share it, or "off"
+	Metrics string `json:"metrics"` // metrics listen address, or "off"
+}
+
+type logConfig struct {
+	Level string `json:"level"` // debug, info, warn or error
+}
+
+type storeConfig struct {
+	Backend          string   `json:"backend"` // memory or redis
+	LeaseTTL         duration `json:"lease_ttl"`
+	TypingTimeout    duration `json:"typing_timeout"`
+	SweepInterval    duration `json:"sweep_interval"`
+	SnapshotPath     string   `json:"snapshot_path"` // memory backend only
+	SnapshotInterval duration `json:"snapshot_interval"`
+}
+
+func (c storeConfig) tunables() tunables {
+	return tunables{
+		leaseTTL:      c.LeaseTTL.Duration,
+		typingTimeout: c.TypingTimeout.Duration,
+		sweepInterval: c.SweepInterval.Duration,
+	}
+}
+
+type redisConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Password string `json:"password"`
+	Prefix   string `json:"prefix"` // of the presence keys, not the sessions'
+}
+
+type tlsConfig struct {
+	CertFile       string   `json:"cert_file"`
+	KeyFile        string   `json:"key_file"`
+	ClientCAFile   string   `json:"client_ca_file"`
+	PeerCAFile     string   `json:"peer_ca_file"` // client_ca_file if empty
+	ReloadInterval duration `json:"reload_interval"`
+}
+
+type clusterConfig struct {
+	Peers          []string `json:"peers"`
+	Advertise      string   `json:"advertise"` // defaults to the hostname and gRPC port
+	GossipInterval duration `json:"gossip_interval"`
+	PeerTimeout    duration `json:"peer_timeout"`
+}
+
+type authConfig struct {
+	Readers       []string `json:"readers"`
+	Writers       []string `json:"writers"`
+	Admins        []string `json:"admins"`
+	AnonymousRole string   `json:"anonymous_role"`
+	APIKeysFile   string   `json:"api_keys_file"`
+	AuditLog      string   `json:"audit_log"` // stdout if empty
+}
+
+type sessionsConfig struct {
+	Validation bool     `json:"validation"`
+	Prefix     string   `json:"prefix"`
+	CacheTTL   duration `json:"cache_ttl"`
+}
+
+type rateLimitConfig struct {
+	User   limitConfig `json:"user"`
+	Caller limitConfig `json:"caller"`
+}
+
+type limitConfig struct {
+	Rate  float64 `json:"rate"` // calls per second, 0 for no limit
+	Burst int     `json:"burst"`
+}
+
+type tracingConfig struct {
+	Exporter string `json:"exporter"` // none, otlp or console
+	File     string `json:"file"`     // console output, stdout if empty
+}
+
+type reloadConfig struct {
+	WatchInterval duration `json:"watch_interval"` // 0 to reload on SIGHUP only
+}
+
+type shutdownConfig struct {
+	PreStopDelay duration `json:"pre_stop_delay"` // NOT_SERVING before draining, for load balancers to notice
+	Timeout      duration `json:"timeout"`        // for calls in flight, after which they are cut off
+}
+
+// duration is a time.Duration written as a string such as "8s".
+type duration struct {
+	time.Duration
+}
+
+func (d duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	d.Duration = v
+	return err
+}
+
+func defaultConfig() *config {
+	t := defaultTunables()
+	return &config{
+		Listen: listenConfig{GRPC: ":50051", HTTP: ":8080", Metrics: ":9090"},
+		Log:    logConfig{Level: "info"},
+		Store: storeConfig{
+			Backend:          "memory",
+			LeaseTTL:         duration{t.leaseTTL},
+			TypingTimeout:    duration{t.typingTimeout},
+			SweepInterval:    duration{t.sweepInterval},
+			SnapshotInterval: duration{30 * time.Second},
+		},
+		Redis:     redisConfig{Host: "redis-service", Port: "6379", Prefix: "presence:"},
+		TLS:       tlsConfig{ReloadInterval: duration{30 * time.Second}},
+		Cluster:   clusterConfig{GossipInterval: duration{time.Second}, PeerTimeout: duration{10 * time.Second}},
+		Sessions:  sessionsConfig{Prefix: "session:", CacheTTL: duration{10 * time.Second}},
+		RateLimit: rateLimitConfig{User: limitConfig{Rate: 10, Burst: 20}},
+		Tracing:   tracingConfig{Exporter: "none"},
+		Reload:    reloadConfig{WatchInterval: duration{10 * time.Second}},
+		Shutdown:  shutdownConfig{Timeout: duration{15 * time.Second}},
+	}
+}
+
+// setting binds a config field to a flag and an environment variable.
+type setting struct {
+	flag, env string
+	value     any // pointer to the config field
+	usage     string
+}
+
+func (c *config) settings() []setting {
+	return []setting{
+		{"grpc-addr", "GRPC_ADDR", &c.Listen.GRPC, "gRPC listen address"},
+		{"http-addr", "HTTP_ADDR", &c.Listen.HTTP, `HTTP/JSON listen address, the gRPC one to share it, or "off"`},
+		{"metrics-addr", "METRICS_ADDR", &c.Listen.Metrics, `metrics listen address, or "off"`},
+		{"log-level", "LOG_LEVEL", &c.Log.Level, "debug, info, warn or error"},
+		{"store-backend", "STORE_BACKEND", &c.Store.Backend, "memory or redis"},
+		{"lease-ttl", "LEASE_TTL", &c.Store.LeaseTTL, "how long a connection or chat node lasts without a heartbeat"},
+		{"typing-timeout", "TYPING_TIMEOUT", &c.Store.TypingTimeout, "how long a typing status lasts without a refresh"},
+		{"sweep-interval", "SWEEP_INTERVAL", &c.Store.SweepInterval, "how often expired state is removed"},
+		{"snapshot-path", "SNAPSHOT_PATH", &c.Store.SnapshotPath, "file to snapshot the memory store to"},
+		{"snapshot-interval", "SNAPSHOT_INTERVAL", &c.Store.SnapshotInterval, "how often to save the snapshot"},
+		{"redis-host", "REDIS_SERVICE_HOST", &c.Redis.Host, "Redis host"},
+		{"redis-port", "REDIS_SERVICE_PORT", &c.Redis.Port, "Redis port"},
+		{"redis-password", "REDIS_PASSWORD", &c.Redis.Password, "Redis password"},
+		{"redis-prefix", "REDIS_PREFIX", &c.Redis.Prefix, "prefix of the presence keys in Redis"},
+		{"tls-cert-file", "TLS_CERT_FILE", &c.TLS.CertFile, "server certificate, enables TLS"},
+		{"tls-key-file", "TLS_KEY_FILE", &c.TLS.KeyFile, "server key"},
+		{"tls-client-ca-file", "TLS_CLIENT_CA_FILE", &c.TLS.ClientCAFile, "CA client certificates must chain to, enables mTLS"},
+		{"tls-peer-ca-file", "TLS_PEER_CA_FILE", &c.TLS.PeerCAFile, "CA cluster peer certificates must chain to, tls-client-ca-file if unset"},
+		{"tls-reload-interval", "TLS_RELOAD_INTERVAL", &c.TLS.ReloadInterval, "how often to check the TLS files for rotation"},
+		{"cluster-peers", "CLUSTER_PEERS", &c.Cluster.Peers, "comma-separated replica addresses, enables cluster mode"},
+		{"cluster-advertise", "CLUSTER_ADVERTISE", &c.Cluster.Advertise, "address peers reach this replica on"},
+		{"cluster-gossip-interval", "CLUSTER_GOSSIP_INTERVAL", &c.Cluster.GossipInterval, "how often to gossip"},
+		{"cluster-peer-timeout", "CLUSTER_PEER_TIMEOUT", &c.Cluster.PeerTimeout, "how long a silent replica is kept"},
+		{"auth-readers", "AUTH_READERS", &c.Auth.Readers, "comma-separated callers with the reader role"},
+		{"auth-writers", "AUTH_WRITERS", &c.Auth.Writers, "comma-separated callers with the writer role"},
+		{"auth-admins", "AUTH_ADMINS", &c.Auth.Admins, "comma-separated callers with the admin role"},
+		{"auth-anonymous-role", "AUTH_ANONYMOUS_ROLE", &c.Auth.AnonymousRole, "role of unidentified callers"},
+		{"auth-api-keys-file", "AUTH_API_KEYS_FILE", &c.Auth.APIKeysFile, "file of name=key lines"},
+		{"auth-audit-log", "AUTH_AUDIT_LOG", &c.Auth.AuditLog, "file denied calls are logged to"},
+		{"session-validation", "SESSION_VALIDATION", &c.Sessions.Validation, "check write RPCs against the session store, and drop connections when their session ends"},
+		{"session-prefix", "SESSION_PREFIX", &c.Sessions.Prefix, "Redis key prefix of sessions"},
+		{"session-cache-ttl", "SESSION_CACHE_TTL", &c.Sessions.CacheTTL, "how long session lookups are cached"},
+		{"rate-limit-user", "RATE_LIMIT_USER", &c.RateLimit.User.Rate, "write calls per second per user and method, 0 for no limit"},
+		{"rate-limit-user-burst", "RATE_LIMIT_USER_BURST", &c.RateLimit.User.Burst, "burst of the user limit"},
+		{"rate-limit-caller", "RATE_LIMIT_CALLER", &c.RateLimit.Caller.Rate, "write calls per second per caller, 0 for no limit"},
+		{"rate-limit-caller-burst", "RATE_LIMIT_CALLER_BURST", &c.RateLimit.Caller.Burst, "burst of the caller limit"},
+		{"tracing-exporter", "OTEL_TRACES_EXPORTER", &c.Tracing.Exporter, "none, otlp or console"},
+		{"tracing-file", "TRACES_FILE", &c.Tracing.File, "file console spans are appended to"},
+		{"config-watch-interval", "CONFIG_WATCH_INTERVAL", &c.Reload.WatchInterval, "how often to check the config file for changes, 0 for SIGHUP only"},
+		{"shutdown-pre-stop-delay", "SHUTDOWN_PRE_STOP_DELAY", &c.Shutdown.PreStopDelay, "how long to report NOT_SERVING before draining on shutdown"},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", &c.Shutdown.Timeout, "how long to wait for calls in flight on shutdown"},
+	}
+}
+
+// set parses s into the config field v points to.
+func set(v any, s string) error {
+	switch v := v.(type) {
+	case *string:
+		*v = s
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*v = f
+	case *duration:
+		return v.UnmarshalText([]byte(s))
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", v))
+	}
+	return nil
+}
+
+// format is the inverse of set.
+func format(v any) string {
+	switch v := v.(type) {
+	case *string:
+		return *v
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	case *float64:
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	case *duration:
+		return v.String()
+	case *[]string:
+		return strings.Join(*v, ",")
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", v))
+	}
+}
+
+// flagSet binds every setting of c to a flag, and -config to configFile.
+func (c *config) flagSet(configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("presence", flag.ContinueOnError)
+	fs.StringVar(configFile, "config", "", "YAML or JSON config file (env CONFIG_FILE)")
+	for _, st := range c.settings() {
+		usage := fmt.Sprintf("%s (env %s)", st.usage, st.env)
+		if v := format(st.value); v != "" && v != "false" {
+			usage = fmt.Sprintf("%s (env %s, default %s)", st.usage, st.env, v)
+		}
+		if _, ok := st.value.(*bool); ok {
+			fs.BoolFunc(st.flag, usage, func(s string) error { return set(st.value, s) })
+		} else {
+			fs.Func(st.flag, usage, func(s string) error { return set(st.value, s) })
+		}
+	}
+	return fs
+}
+
+// loadConfig reads the config from the file, environment and flags in args,
+// and validates it.
+func loadConfig(args []string, getenv func(string) string) (*config, error) {
+	// Flags win over everything, but name the file, so they are parsed once
+	// to find it and again on top of it.
+	var configFile string
+	if err := defaultConfig().flagSet(&configFile).Parse(args); err != nil {
+		return nil, err
+	}
+	if configFile == "" {
+		configFile = getenv("CONFIG_FILE")
+	}
+
+	c := defaultConfig()
+	c.file = configFile
+	if configFile != "" {
+		if err := c.loadFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+	// PORT and METRICS_PORT predate the address settings.
+	if port := getenv("PORT"); port != "" {
+		c.Listen.GRPC = ":" + port
+	}
+	if port := getenv("METRICS_PORT"); port == "off" {
+		c.Listen.Metrics = port
+	} else if port != "" {
+		c.Listen.Metrics = ":" + port
+	}
+	for _, st := range c.settings() {
+		if v := getenv(st.env); v != "" {
+			if err := set(st.value, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", st.env, err)
+			}
+		}
+	}
+	if err := c.flagSet(&configFile).Parse(args); err != nil {
+		return nil, err
+	}
+	return c, c.validate()
+}
+
+// loadFile reads a YAML file, or JSON if it ends in .json, over c. Unknown
+// keys are errors, so typos do not go unnoticed.
+func (c *config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) != ".json" {
+		// YAML is decoded through JSON, so that both use the json tags.
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once.
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Listen.GRPC != "", "listen.grpc is required")
+	check(c.Listen.HTTP != "", `listen.http is required, use "off" to disable it`)
+	check(c.Listen.Metrics != "", `listen.metrics is required, use "off" to disable it`)
+	check(c.Listen.Metrics == "off" || c.Listen.Metrics != c.Listen.GRPC && c.Listen.Metrics != c.Listen.HTTP,
+		"listen.metrics: must not share a port with listen.grpc or listen.http")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level: unknown level %q", c.Log.Level)
+
+	check(slices.Contains([]string{"memory", "redis"}, c.Store.Backend), "store.backend: must be memory or redis, got %q", c.Store.Backend)
+	for name, d := range map[string]duration{
+		"store.lease_ttl":         c.Store.LeaseTTL,
+		"store.typing_timeout":    c.Store.TypingTimeout,
+		"store.sweep_interval":    c.Store.SweepInterval,
+		"store.snapshot_interval": c.Store.SnapshotInterval,
+		"tls.reload_interval":     c.TLS.ReloadInterval,
+		"cluster.gossip_interval": c.Cluster.GossipInterval,
+		"cluster.peer_timeout":    c.Cluster.PeerTimeout,
+		"sessions.cache_ttl":      c.Sessions.CacheTTL,
+		"shutdown.timeout":        c.Shutdown.Timeout,
+	} {
+		check(d.Duration > 0, "%s: must be positive, got %s", name, d)
+	}
+	check(c.Reload.WatchInterval.Duration >= 0, "reload.watch_interval: must not be negative")
+	check(c.Shutdown.PreStopDelay.Duration >= 0, "shutdown.pre_stop_delay: must not be negative")
+	check(c.Store.SweepInterval.Duration <= c.Store.TypingTimeout.Duration,
+		"store.sweep_interval: must not exceed store.typing_timeout")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.client_ca_file needs tls.cert_file")
+	check(c.TLS.PeerCAFile == "" || c.TLS.CertFile != "", "tls.peer_ca_file needs tls.cert_file")
+	check(len(c.Cluster.Peers) == 0 || c.TLS.CertFile == "" || c.TLS.ClientCAFile != "" || c.TLS.PeerCAFile != "",
+		"cluster.peers: with TLS, tls.client_ca_file or tls.peer_ca_file is needed to verify peers")
+	check(len(c.Cluster.Peers) == 0 || c.Store.Backend == "memory", "cluster.peers: cluster mode needs store.backend memory")
+	if c.Auth.AnonymousRole != "" {
+		_, err := parseRole(c.Auth.AnonymousRole)
+		check(err == nil, "auth.anonymous_role: %v", err)
+	}
+	check(!c.Sessions.Validation || c.Sessions.Prefix != "", "sessions.prefix is required with sessions.validation")
+	for name, l := range map[string]limitConfig{"rate_limit.user": c.RateLimit.User, "rate_limit.caller": c.RateLimit.Caller} {
+		check(l.Rate >= 0 && l.Burst >= 0, "%s: rate and burst must not be negative", name)
+	}
+	check(slices.Contains([]string{"none", "otlp", "console"}, c.Tracing.Exporter), "tracing.exporter: must be none, otlp or console, got %q", c.Tracing.Exporter)
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// redacted returns a copy of c safe to log.
+func (c config) redacted() config {
+	if c.Redis.Password != "" {
+		c.Redis.Password = "REDACTED"
+	}
+	return c
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "presence.yaml")
+	os.WriteFile(file, []byte(`
+listen:
+  grpc: ":6000"
+store:
+  typing_timeout: 5s
+  sweep_interval: 500ms
+auth:
+  writers: [chat, bots]
+rate_limit:
+  user: {rate: 3, burst: 6}
+`), 0o600)
+
+	env := map[string]string{
+		"CONFIG_FILE":     file,
+		"TYPING_TIMEOUT":  "4s",
+		"METRICS_PORT":    "9100",
+		"RATE_LIMIT_USER": "2",
+	}
+	cfg, err := loadConfig([]string{"-rate-limit-user=1", "-session-validation"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Listen.GRPC != ":6000" {
+		t.Errorf("listen.grpc = %q, want the file's :6000", cfg.Listen.GRPC)
+	}
+	if cfg.Listen.Metrics != ":9100" {
+		t.Errorf("listen.metrics = %q, want :9100 from METRICS_PORT", cfg.Listen.Metrics)
+	}
+	if cfg.Store.TypingTimeout.Duration != 4*time.Second {
+		t.Errorf("store.typing_timeout = %s, want the env's 4s", cfg.Store.TypingTimeout)
+	}
+	if cfg.Store.SweepInterval.Duration != 500*time.Millisecond {
+		t.Errorf("store.sweep_interval = %s, want the file's 500ms", cfg.Store.SweepInterval)
+	}
+	if cfg.Store.LeaseTTL.Duration != defaultTunables().leaseTTL {
+		t.Errorf("store.lease_ttl = %s, want the default", cfg.Store.LeaseTTL)
+	}
+	if got := strings.Join(cfg.Auth.Writers, ","); got != "chat,bots" {
+		t.Errorf("auth.writers = %q, want chat,bots", got)
+	}
+	if cfg.RateLimit.User.Rate != 1 || cfg.RateLimit.User.Burst != 6 {
+		t.Errorf("rate_limit.user = %+v, want the flag's rate and the file's burst", cfg.RateLimit.User)
+	}
+	if !cfg.Sessions.Validation {
+		t.Error("sessions.validation not set by its flag")
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "presence.json")
+	os.WriteFile(file, []byte(`{"store": {"backend": "redis"}, "redis": {"password": "hunter2"}}`), 0o600)
+
+	cfg, err := loadConfig([]string{"-config", file}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Store.Backend != "redis" {
+		t.Errorf("store.backend = %q, want redis", cfg.Store.Backend)
+	}
+	if got := cfg.redacted().Redis.Password; got != "REDACTED" {
+		t.Errorf("redacted password = %q", got)
+	}
+	if cfg.Redis.Password != "hunter2" {
+		t.Error("redacted changed the config")
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	file := filepath.Join(t.TempDir(), "presence.yaml")
+	os.WriteFile(file, []byte("store:\n  typing_timeot: 5s\n"), 0o600)
+	if _, err := loadConfig([]string{"-config", file}, noEnv); err == nil || !strings.Contains(err.Error(), "typing_timeot") {
+		t.Errorf("unknown key: err = %v", err)
+	}
+
+	if _, err := loadConfig(nil, func(k string) string { return map[string]string{"LEASE_TTL": "soon"}[k] }); err == nil || !strings.Contains(err.Error(), "LEASE_TTL") {
+		t.Errorf("unparsable env: err = %v", err)
+	}
+
+	_, err := loadConfig([]string{
+		"-store-backend", "disk",
+		"-typing-timeout", "0s",
+		"-auth-anonymous-role", "root",
+		"-tls-key-file", "server.key",
+		"-shutdown-pre-stop-delay", "-1s",
+		"-log-level", "verbose",
+	}, noEnv)
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, want := range []string{"store.backend", "store.typing_timeout", "auth.anonymous_role", "tls.cert_file", "shutdown.pre_stop_delay", "log.level"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
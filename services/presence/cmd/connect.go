@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"connectrpc.com/connect"
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// handleConnect serves every PresenceService method on mux at its gRPC path
+// over the Connect, gRPC-Web and gRPC protocols, which browsers can speak
+// through HTTP/1.1 proxies. Calls run through the same interceptors as the
+// gRPC server's.
+func handleConnect(mux *http.ServeMux, impl pb.PresenceServiceServer, unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) {
+	mux.Handle(connectUnary(pb.PresenceService_UserConnected_FullMethodName, impl.UserConnected, unary))
+	mux.Handle(connectUnary(pb.PresenceService_UserDisconnected_FullMethodName, impl.UserDisconnected, unary))
+	mux.Handle(connectUnary(pb.PresenceService_Heartbeat_FullMethodName, impl.Heartbeat, unary))
+	mux.Handle(connectUnary(pb.PresenceService_SetTyping_FullMethodName, impl.SetTyping, unary))
+	mux.Handle(connectUnary(pb.PresenceService_SetStatus_FullMethodName, impl.SetStatus, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetOnlineUsers_FullMethodName, impl.GetOnlineUsers, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetTypingUsers_FullMethodName, impl.GetTypingUsers, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetUserConnections_FullMethodName, impl.GetUserConnections, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetLastSeen_FullMethodName, impl.GetLastSeen, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetPresence_FullMethodName, impl.GetPresence, unary))
+	mux.Handle(connectUnary(pb.PresenceService_JoinRoom_FullMethodName, impl.JoinRoom, unary))
+	mux.Handle(connectUnary(pb.PresenceService_LeaveRoom_FullMethodName, impl.LeaveRoom, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetRoomOnlineUsers_FullMethodName, impl.GetRoomOnlineUsers, unary))
+	mux.Handle(connectUnary(pb.PresenceService_GetRoomTypingUsers_FullMethodName, impl.GetRoomTypingUsers, unary))
+	mux.Handle(connectUnary(pb.PresenceService_RegisterNode_FullMethodName, impl.RegisterNode, unary))
+	mux.Handle(connectUnary(pb.PresenceService_PurgeNode_FullMethodName, impl.PurgeNode, unary))
+	mux.Handle(connectServerStream(pb.PresenceService_WatchPresence_FullMethodName, impl.WatchPresence, stream))
+}
+
+// connectOptions are the handler options of method. Reads may also be called
+// with GET, which Connect clients do for side-effect-free methods.
+func connectOptions(method string) []connect.HandlerOption {
+	opts := []connect.HandlerOption{connect.WithReadMaxBytes(maxRequestBody)}
+	if requiredRole(method) == roleReader {
+		opts = append(opts, connect.WithIdempotency(connect.IdempotencyNoSideEffects))
+	}
+	return opts
+}
+
+// connectUnary returns the path and handler of a unary method.
+func connectUnary[Req, Res any](method string, call func(context.Context, *Req) (*Res, error), chain grpc.UnaryServerInterceptor) (string, http.Handler) {
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	h := connect.NewUnaryHandlerSimple(method, func(ctx context.Context, req *Req) (*Res, error) {
+		bindCallViewer(ctx, any(req).(proto.Message))
+		resp, err := chain(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return call(ctx, req.(*Req))
+		})
+		if err != nil {
+			return nil, connectError(ctx, err)
+		}
+		return resp.(*Res), nil
+	}, connectOptions(method)...)
+	return method, withCallContext(method, h)
+}
+
+// connectServerStream returns the path and handler of a server-streaming method.
+func connectServerStream[Req, Res any](method string, call func(*Req, grpc.ServerStreamingServer[Res]) error, chain grpc.StreamServerInterceptor) (string, http.Handler) {
+	info := &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}
+	h := connect.NewServerStreamHandlerSimple(method, func(ctx context.Context, req *Req, out *connect.ServerStream[Res]) error {
+		bindCallViewer(ctx, any(req).(proto.Message))
+		ss := &connectStream[Res]{ctx: ctx, sentRequest: sentRequest{req: any(req).(proto.Message)}, out: out}
+		err := chain(nil, ss, info, func(_ any, ss grpc.ServerStream) error {
+			in := new(Req)
+			if err := ss.RecvMsg(in); err != nil {
+				return err
+			}
+			return call(in, &grpc.GenericServerStream[Req, Res]{ServerStream: ss})
+		})
+		if err != nil {
+			return connectError(ctx, err)
+		}
+		return nil
+	}, connectOptions(method)...)
+	return method, withCallContext(method, h)
+}
+
+// withCallContext runs h in the context callContext sets up for method.
+func withCallContext(method string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := callContext(r, method)
+		defer span.End()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bindCallViewer is bindViewer with the request headers of the call ctx belongs to.
+func bindCallViewer(ctx context.Context, req proto.Message) {
+	if call, ok := connect.CallInfoForHandlerContext(ctx); ok {
+		bindViewer(call.RequestHeader(), req)
+	}
+}
+
+// connectError converts a gRPC status error, keeping its details, and marks
+// the call's span as failed.
+func connectError(ctx context.Context, err error) error {
+	trace.SpanFromContext(ctx).SetStatus(otelcodes.Error, err.Error())
+	st := status.Convert(err)
+	ce := connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, d := range st.Details() {
+		if msg, ok := d.(proto.Message); ok {
+			if detail, err := connect.NewErrorDetail(msg); err == nil {
+				ce.AddDetail(detail)
+			}
+		}
+	}
+	return ce
+}
+
+// sentRequest is the receiving side of a server-streaming call whose request
+// was read before the call: RecvMsg yields it once.
+type sentRequest struct {
+	req  proto.Message
+	read bool
+}
+
+func (s *sentRequest) RecvMsg(m any) error {
+	if s.read {
+		return io.EOF
+	}
+	s.read = true
+	proto.Merge(m.(proto.Message), s.req)
+	return nil
+}
+
+// connectStream is the grpc.ServerStream of a server-streaming call made with
+// connect. It sends responses to out.
+type connectStream[Res any] struct {
+	sentRequest
+	ctx context.Context
+	out *connect.ServerStream[Res]
+}
+
+func (s *connectStream[Res]) Context() context.Context { return s.ctx }
+
+func (s *connectStream[Res]) SendMsg(m any) error { return s.out.Send(m.(*Res)) }
+
+func (s *connectStream[Res]) SetHeader(md metadata.MD) error {
+	copyMetadata(s.out.ResponseHeader(), md)
+	return nil
+}
+
+// SendHeader only sets the headers; connect sends them with the first message.
+func (s *connectStream[Res]) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *connectStream[Res]) SetTrailer(md metadata.MD) { copyMetadata(s.out.ResponseTrailer(), md) }
+
+func copyMetadata(h http.Header, md metadata.MD) {
+	for k, vs := range md {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+}
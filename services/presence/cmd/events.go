@@ -0,0 +1,249 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// eventType identifies a presence state transition.
+type eventType int
+
+const (
+	eventOnline eventType = iota + 1
+	eventOffline
+	eventTypingStarted
+	eventTypingStopped
+	eventTypingExpired
+	eventJoined
+	eventLeft
+	eventStatusChanged
+	eventGoingAway // the server is shutting down; sent to every watcher
+)
+
+// audience restricts which watchers see an event, relative to its username.
+type audience int
+
+const (
+	audienceAll audience = iota
+	audienceSelf
+	audienceOthers
+)
+
+// event is a single presence change published to watchers. room is empty for
+// global transitions (online, offline, global typing, status); a status change
+// also reaches watchers of the rooms listed in rooms. seq increases with every
+// event a backend publishes.
+type event struct {
+	seq      uint64
+	typ      eventType
+	username string
+	room     string
+	at       time.Time
+	audience audience
+	status   userStatus // eventStatusChanged only
+	rooms    []string   // eventStatusChanged only: the user's rooms
+}
+
+// snapshot is the state a watcher starts from.
+type snapshot struct {
+	seq      uint64 // last event reflected
+	online   []string
+	typing   []string
+	statuses []userStatus // one per online user
+}
+
+// watcherBuffer is how many events a watcher may lag behind before it is dropped.
+const watcherBuffer = 64
+
+type watcher struct {
+	room   string // only deliver events for this room; empty means everything
+	viewer string // username watching, for invisible users' own events
+	after  uint64 // skip events already reflected in the snapshot
+	ch     chan event
+}
+
+// hub fans events out to watchers and keeps the most recent ones so a watcher
+// can catch up from a sequence number. It does no locking of its own: owners
+// guard it with the lock that protects their state, so that taking a snapshot
+// and subscribing happen atomically.
+type hub struct {
+	watchers map[*watcher]struct{}
+	recent   []event // oldest first, at most hubHistory
+	away     *event  // set once the server is going away
+}
+
+// hubHistory is how many past events a hub keeps for catching up.
+const hubHistory = 1024
+
+func newHub() hub {
+	return hub{watchers: make(map[*watcher]struct{})}
+}
+
+// add registers a watcher that receives every event after seq after, replaying
+// the ones the hub already sent. It reports false if those events are no
+// longer in the history.
+func (h *hub) add(room, viewer string, after uint64) (*watcher, bool) {
+	if h.away != nil {
+		w := &watcher{ch: make(chan event, 1)}
+		w.ch <- *h.away
+		close(w.ch)
+		return w, true
+	}
+	if n := len(h.recent); n > 0 && h.recent[n-1].seq > after && h.recent[0].seq > after+1 {
+		return nil, false
+	}
+	w := &watcher{room: room, viewer: viewer, after: after, ch: make(chan event, watcherBuffer)}
+	h.watchers[w] = struct{}{}
+	for _, ev := range h.recent {
+		h.deliver(w, ev)
+	}
+	return w, true
+}
+
+// covers reports whether the events after seq after can still be replayed, last
+// being the last event the hub's owner published or relayed.
+func (h *hub) covers(after, last uint64) bool {
+	if after == last {
+		return true
+	}
+	return after < last && len(h.recent) > 0 && h.recent[0].seq <= after+1
+}
+
+func (h *hub) remove(w *watcher) {
+	if _, ok := h.watchers[w]; ok {
+		delete(h.watchers, w)
+		close(w.ch)
+	}
+}
+
+// reset drops every watcher and the history, forcing watchers to resubscribe.
+func (h *hub) reset() {
+	for w := range h.watchers {
+		h.remove(w)
+	}
+	h.recent = nil
+}
+
+// goAway sends ev to every watcher, whatever it watches, and drops them. Later
+// watchers get it straight away.
+func (h *hub) goAway(ev event) {
+	h.away = &ev
+	for w := range h.watchers {
+		select {
+		case w.ch <- ev:
+		default:
+			w.ch <- takeBack(w.ch, ev)
+		}
+		h.remove(w)
+	}
+}
+
+// takeBack empties the full channel of a watcher so that ev fits, and returns
+// ev with the seq to resume from to get the events taken back.
+func takeBack(ch chan event, ev event) event {
+	for first := true; ; first = false {
+		select {
+		case pending := <-ch:
+			if first {
+				ev.seq = pending.seq - 1
+			}
+		default:
+			return ev
+		}
+	}
+}
+
+// send records an event and delivers it to the watchers it is meant for.
+func (h *hub) send(ev event) {
+	if len(h.recent) == hubHistory {
+		h.recent = h.recent[1:]
+	}
+	h.recent = append(h.recent, ev)
+	for w := range h.watchers {
+		h.deliver(w, ev)
+	}
+}
+
+func (h *hub) deliver(w *watcher, ev event) {
+	if ev.seq <= w.after || w.room != "" && w.room != ev.room && !slices.Contains(ev.rooms, w.room) {
+		return
+	}
+	if ev.audience == audienceSelf && w.viewer != ev.username ||
+		ev.audience == audienceOthers && w.viewer == ev.username {
+		return
+	}
+	select {
+	case w.ch <- ev:
+	default:
+		// Slow consumer: drop it rather than block every mutation.
+		h.remove(w)
+	}
+}
+
+// watch registers a watcher and returns the current state along with a channel
+// of subsequent events. The snapshot and the subscription are taken under the
+// same lock, so no change is missed or delivered twice. When room is set, both
+// the snapshot and the events are limited to that room. The channel is closed
+// if the watcher falls too far behind; cancel must be called when done.
+func (s *store) watch(room, viewer string) (snap snapshot, events <-chan event, cancel func(), err error) {
+	s.mu.Lock()
+	if room == "" {
+		snap.online = s.onlineUsersLocked(viewer)
+		snap.typing = s.typingUsersLocked(viewer)
+	} else {
+		snap.online = s.roomOnlineUsersLocked(room, viewer)
+		snap.typing = s.roomTypingUsersLocked(room, viewer)
+	}
+	snap.statuses = s.statusesLocked(snap.online)
+	snap.seq = s.seq
+	w, _ := s.hub.add(room, viewer, s.seq)
+	s.mu.Unlock()
+
+	cancel = func() {
+		s.mu.Lock()
+		s.hub.remove(w)
+		s.mu.Unlock()
+	}
+	return snap, w.ch, cancel, nil
+}
+
+// resume registers a watcher for the events after seq after, like watch
+// without the snapshot. It reports false if they can no longer be replayed.
+func (s *store) resume(room, viewer string, after uint64) (events <-chan event, cancel func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hub.covers(after, s.seq) {
+		return nil, nil, false
+	}
+	w, _ := s.hub.add(room, viewer, after)
+	return w.ch, func() {
+		s.mu.Lock()
+		s.hub.remove(w)
+		s.mu.Unlock()
+	}, true
+}
+
+// goAway tells watchers the server is shutting down. The event carries the
+// last seq, so that they can resume elsewhere.
+func (s *store) goAway() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hub.goAway(event{seq: s.seq, typ: eventGoingAway, at: time.Now()})
+}
+
+// publishLocked publishes a transition. Invisible users' transitions are only
+// shown to themselves. Caller must hold s.mu.
+func (s *store) publishLocked(typ eventType, username, room string, at time.Time) {
+	ev := event{typ: typ, username: username, room: room, at: at}
+	if !s.visibleLocked(username, "") {
+		ev.audience = audienceSelf
+	}
+	s.sendLocked(ev)
+}
+
+// sendLocked numbers an event and fans it out to watchers. Caller must hold s.mu.
+func (s *store) sendLocked(ev event) {
+	s.seq++
+	ev.seq = s.seq
+	s.hub.send(ev)
+}
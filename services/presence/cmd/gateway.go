@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpRoutes transcode REST calls to PresenceService methods. Fields of the
+// request message come from the JSON body, path wildcards and query
+// parameters, in that order. WatchPresence streams, so it is only served by
+// handleConnect.
+var httpRoutes = []struct {
+	pattern, method string
+}{
+	{"POST /presence/connect", "UserConnected"},
+	{"POST /presence/disconnect", "UserDisconnected"},
+	{"POST /presence/heartbeat", "Heartbeat"},
+	{"POST /presence/typing", "SetTyping"},
+	{"POST /presence/status", "SetStatus"},
+	{"GET /presence/online", "GetOnlineUsers"},
+	{"GET /presence/typing", "GetTypingUsers"},
+	{"GET /presence/users", "GetPresence"},
+	{"GET /presence/last-seen", "GetLastSeen"},
+	{"POST /presence/rooms/{room}/join", "JoinRoom"},
+	{"POST /presence/rooms/{room}/leave", "LeaveRoom"},
+	{"GET /presence/rooms/{room}/online", "GetRoomOnlineUsers"},
+	{"GET /presence/rooms/{room}/typing", "GetRoomTypingUsers"},
+	{"POST /presence/nodes/{node}/register", "RegisterNode"},
+	{"POST /presence/nodes/{node}/purge", "PurgeNode"},
+}
+
+// forwardedHeaders are the HTTP headers passed on to handlers as metadata.
+var forwardedHeaders = []string{apiKeyHeader, sessionHeader, "traceparent", "tracestate", "baggage"}
+
+// authenticatedUserHeader is the user the gateway authenticated the request
+// as. nginx sets it from the session, replacing any value the client sent.
+const authenticatedUserHeader = "X-Authenticated-User"
+
+// sessionCookie carries the session token of browsers, which cannot set
+// headers on every request; it is the cookie the accounts service sets.
+const sessionCookie = "auth_token"
+
+const maxRequestBody = 1 << 20
+
+// newGateway returns the HTTP handler of httpRoutes, handleConnect's protocols
+// and handleEvents' stream. Calls go to impl through unary and stream, the interceptors the
+// gRPC server runs, so authorization, rate limits, session checks and metrics
+// apply alike.
+func newGateway(impl pb.PresenceServiceServer, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor) http.Handler {
+	mux := http.NewServeMux()
+	chain, streamChain := chainUnaryInterceptors(unary), chainStreamInterceptors(stream)
+	handleConnect(mux, impl, chain, streamChain)
+	handleEvents(mux, impl, streamChain)
+	desc := pb.PresenceService_ServiceDesc
+	for _, route := range httpRoutes {
+		for _, m := range desc.Methods {
+			if m.MethodName == route.method {
+				mux.Handle(route.pattern, &gatewayMethod{
+					fullMethod: "/" + desc.ServiceName + "/" + m.MethodName,
+					handler:    m.Handler,
+					impl:       impl,
+					chain:      chain,
+				})
+			}
+		}
+	}
+	return mux
+}
+
+// chainUnaryInterceptors runs interceptors in order, like grpc.ChainUnaryInterceptor.
+func chainUnaryInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// chainStreamInterceptors runs interceptors in order, like grpc.ChainStreamInterceptor.
+func chainStreamInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv any, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, inner)
+			}
+		}
+		return next(srv, ss)
+	}
+}
+
+type gatewayMethod struct {
+	fullMethod string
+	handler    grpc.MethodHandler
+	impl       any
+	chain      grpc.UnaryServerInterceptor
+}
+
+func (g *gatewayMethod) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := callContext(r, g.fullMethod)
+	defer span.End()
+	resp, err := g.handler(g.impl, ctx, func(m any) error { return decodeRequest(r, m.(proto.Message)) }, g.chain)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+		writeHTTPError(w, err)
+		return
+	}
+	body, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(resp.(proto.Message))
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// callContext returns the context to call method in for r, set up as the gRPC
+// server would, and the call's span, which the caller ends.
+func callContext(r *http.Request, method string) (context.Context, trace.Span) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := otel.Tracer("presence").Start(ctx, strings.TrimPrefix(method, "/"), trace.WithSpanKind(trace.SpanKindServer))
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &gatewayStream{method: method})
+	ctx = metadata.NewIncomingContext(ctx, requestMetadata(r))
+	return peer.NewContext(ctx, requestPeer(r)), span
+}
+
+// requestMetadata copies forwardedHeaders, and the session cookie if there is
+// no session header.
+func requestMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for _, h := range forwardedHeaders {
+		if v := r.Header.Values(h); len(v) > 0 {
+			md.Set(h, v...)
+		}
+	}
+	if len(md.Get(sessionHeader)) == 0 {
+		if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+			md.Set(sessionHeader, c.Value)
+		}
+	}
+	return md
+}
+
+// requestPeer describes the client the way gRPC would, so that the client
+// certificate identifies the caller and its IP is rate limited.
+func requestPeer(r *http.Request) *peer.Peer {
+	p := &peer.Peer{Addr: httpAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS, CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}
+	}
+	return p
+}
+
+// httpAddr is the net.Addr of an http.Request's RemoteAddr.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+// decodeRequest fills m from the request's JSON body, path wildcards and
+// query parameters.
+func decodeRequest(r *http.Request, m proto.Message) error {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxRequestBody))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "read body: %v", err)
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := protojson.Unmarshal(body, m); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid JSON body: %v", err)
+		}
+	}
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for i := range fields.Len() {
+		fd := fields.Get(i)
+		if v := r.PathValue(string(fd.Name())); v != "" {
+			if err := setField(msg, fd, []string{v}); err != nil {
+				return err
+			}
+		}
+	}
+	for name, values := range r.URL.Query() {
+		fd := fields.ByJSONName(name)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(name))
+		}
+		if fd == nil {
+			return status.Errorf(codes.InvalidArgument, "unknown parameter %q", name)
+		}
+		if err := setField(msg, fd, values); err != nil {
+			return err
+		}
+	}
+	bindViewer(r.Header, m)
+	return nil
+}
+
+// bindViewer sets the viewer of m, if it has one, to the authenticated user
+// of a request that came through the gateway, so that nobody sees presence
+// through someone else's eyes, e.g. an invisible user's. Services calling from
+// inside the cluster pass no such header and keep the viewer they asked for.
+func bindViewer(h http.Header, m proto.Message) {
+	users := h.Values(authenticatedUserHeader)
+	if len(users) == 0 {
+		return
+	}
+	msg := m.ProtoReflect()
+	if fd := msg.Descriptor().Fields().ByName("viewer"); fd != nil {
+		msg.Set(fd, protoreflect.ValueOfString(users[0]))
+	}
+}
+
+// setField sets a scalar field to the last of values, or appends them all,
+// comma-separated or repeated, to a repeated field.
+func setField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, values []string) error {
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, v := range values {
+			for _, item := range strings.Split(v, ",") {
+				pv, err := parseScalar(fd, item)
+				if err != nil {
+					return err
+				}
+				list.Append(pv)
+			}
+		}
+		return nil
+	}
+	pv, err := parseScalar(fd, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, pv)
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	invalid := func(err error) (protoreflect.Value, error) {
+		return protoreflect.Value{}, status.Errorf(codes.InvalidArgument, "invalid %s: %v", fd.Name(), err)
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Int32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt32(int32(n)), nil
+	case protoreflect.Int64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt64(n), nil
+	case protoreflect.Uint64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint64(n), nil
+	case protoreflect.EnumKind:
+		if v := fd.Enum().Values().ByName(protoreflect.Name(s)); v != nil {
+			return protoreflect.ValueOfEnum(v.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return invalid(fmt.Errorf("unknown value %q", s))
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	default:
+		return invalid(fmt.Errorf("%s fields cannot be set from the URL", fd.Kind()))
+	}
+}
+
+// httpStatuses maps gRPC codes to HTTP statuses, as Google's API gateways do.
+var httpStatuses = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499, // client closed request
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// writeHTTPError writes err as a JSON google.rpc.Status with the HTTP status
+// of its code, and a Retry-After header if it says when to retry.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(ri.RetryDelay.AsDuration().Round(time.Second)/time.Second)+1))
+		}
+	}
+	body, merr := protojson.Marshal(st.Proto())
+	if merr != nil {
+		slog.Error("failed to encode error", "error", merr)
+		body = []byte(`{"code":13,"message":"internal error"}`)
+	}
+	code, ok := httpStatuses[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// gatewayStream is the grpc.ServerTransportStream of a transcoded call. Only
+// the method name is of use; headers set by handlers are dropped.
+type gatewayStream struct {
+	method string
+}
+
+func (s *gatewayStream) Method() string               { return s.method }
+func (s *gatewayStream) SetHeader(metadata.MD) error  { return nil }
+func (s *gatewayStream) SendHeader(metadata.MD) error { return nil }
+func (s *gatewayStream) SetTrailer(metadata.MD) error { return nil }
+
+// grpcOrHTTP sends gRPC requests to grpcSrv and everything else, gRPC-Web
+// included, to h, so that both can share a listener.
+func grpcOrHTTP(grpcSrv *grpc.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct := r.Header.Get("Content-Type")
+		if r.ProtoMajor == 2 && (ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")) {
+			grpcSrv.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newHTTPServer serves h over HTTP/1.1 and HTTP/2, including unencrypted
+// HTTP/2, which gRPC clients use on a shared plaintext port.
+func newHTTPServer(h http.Handler) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{Handler: h, Protocols: &protocols, ReadHeaderTimeout: 10 * time.Second}
+}
+
+// serveHTTP serves srv on lis, with TLS when tlsConfig is set, until srv is
+// shut down.
+func serveHTTP(srv *http.Server, lis net.Listener, tlsConfig *tls.Config) error {
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, withALPN(tlsConfig))
+	}
+	if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// withALPN offers HTTP/2 and HTTP/1.1 during the handshake, including with
+// the configs c returns per client.
+func withALPN(c *tls.Config) *tls.Config {
+	protos := []string{"h2", "http/1.1"}
+	c = c.Clone()
+	c.NextProtos = protos
+	if get := c.GetConfigForClient; get != nil {
+		c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := get(hello)
+			if cfg != nil {
+				cfg = cfg.Clone()
+				cfg.NextProtos = protos
+			}
+			return cfg, err
+		}
+	}
+	return c
+}
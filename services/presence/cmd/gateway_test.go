@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// serveGateway serves PresenceService on store over gRPC and HTTP on one
+// port, behind the API key "chat-key" (writer) and a user rate limit of 2
+// calls, and returns the HTTP base URL and a gRPC client.
+func serveGateway(t *testing.T, store backend) (string, pb.PresenceServiceClient) {
+	t.Helper()
+	a := newAuthorizer(&authPolicy{
+		enforce:   true,
+		roles:     map[string]role{"chat": roleWriter},
+		apiKeys:   map[[32]byte]string{sha256.Sum256([]byte("chat-key")): "chat"},
+		anonymous: roleReader,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	limits := newRateLimits(newRateLimiter(0.001, 2), nil)
+	unary := []grpc.UnaryServerInterceptor{identityUnaryInterceptor, a.unaryInterceptor, limits.unaryInterceptor}
+	stream := []grpc.StreamServerInterceptor{identityStreamInterceptor, a.streamInterceptor}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	presence := &server{store: store}
+	pb.RegisterPresenceServiceServer(srv, presence)
+	httpSrv := newHTTPServer(grpcOrHTTP(srv, newGateway(presence, unary, stream)))
+	go serveHTTP(httpSrv, lis, nil)
+	t.Cleanup(func() {
+		httpSrv.Close()
+		srv.Stop()
+	})
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return "http://" + lis.Addr().String(), pb.NewPresenceServiceClient(conn)
+}
+
+func TestGateway(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	base, client := serveGateway(t, s)
+
+	call := func(method, path, body string, header ...string) (int, http.Header, map[string]any) {
+		t.Helper()
+		req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp.StatusCode, resp.Header, out
+	}
+	key := []string{apiKeyHeader, "chat-key"}
+
+	code, _, out := call("POST", "/presence/connect", `{"username":"alice","connectionId":"a1","room":"r1"}`, key...)
+	if code != http.StatusOK || !slices.Equal(toStrings(out["usernames"]), []string{"alice"}) {
+		t.Fatalf("connect alice: %d %v", code, out)
+	}
+	if code, _, out = call("POST", "/presence/connect", `{"username":"bob"}`); code != http.StatusForbidden {
+		t.Errorf("anonymous connect: %d %v, want 403", code, out)
+	}
+	if code, _, out = call("POST", "/presence/connect", `{"username":"bob"}`, apiKeyHeader, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("unknown API key: %d %v, want 401", code, out)
+	}
+	if code, _, out = call("POST", "/presence/heartbeat", `{"username":"alice","connection_id":"nope"}`, key...); code != http.StatusNotFound || out["message"] == "" {
+		t.Errorf("heartbeat of an unknown connection: %d %v, want 404", code, out)
+	}
+	if code, _, out = call("POST", "/presence/connect", `{"username":`, key...); code != http.StatusBadRequest {
+		t.Errorf("malformed body: %d %v, want 400", code, out)
+	}
+
+	// Path wildcards and query parameters fill the request.
+	if _, err := client.UserConnected(metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, "chat-key"), &pb.UserRequest{Username: "bob", ConnectionId: "b1"}); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, out = call("POST", "/presence/rooms/r1/join?username=bob", "", key...); code != http.StatusOK || !slices.Equal(toStrings(out["usernames"]), []string{"alice", "bob"}) {
+		t.Errorf("bob joins r1: %d %v", code, out)
+	}
+	if code, _, out = call("GET", "/presence/users?usernames=alice,carol", ""); code != http.StatusOK {
+		t.Fatalf("presence: %d %v", code, out)
+	}
+	users := out["users"].([]any)
+	if len(users) != 2 || users[0].(map[string]any)["online"] != true || users[1].(map[string]any)["online"] != false {
+		t.Errorf("presence of alice and carol = %v", users)
+	}
+	if code, _, out = call("POST", "/presence/status?status=INVISIBLE", `{"username":"alice"}`, key...); code != http.StatusOK || out["status"] != "INVISIBLE" {
+		t.Errorf("set status: %d %v", code, out)
+	}
+
+	// The viewer is the user the gateway authenticated, whatever the query says.
+	if code, _, out = call("GET", "/presence/online?viewer=alice", "", authenticatedUserHeader, "bob"); code != http.StatusOK || !slices.Equal(toStrings(out["usernames"]), []string{"bob"}) {
+		t.Errorf("online users for bob asking as alice: %d %v", code, out)
+	}
+	if code, _, out = call("GET", "/presence/online", "", authenticatedUserHeader, "alice"); code != http.StatusOK || !slices.Equal(toStrings(out["usernames"]), []string{"alice", "bob"}) {
+		t.Errorf("online users for alice: %d %v", code, out)
+	}
+	if code, _, out = call("GET", "/presence/online?bogus=1", ""); code != http.StatusBadRequest {
+		t.Errorf("unknown parameter: %d %v, want 400", code, out)
+	}
+
+	// Rate limits say when to retry.
+	typing := `{"username":"carol","isTyping":true}`
+	for range 2 {
+		call("POST", "/presence/typing", typing, key...)
+	}
+	code, header, out := call("POST", "/presence/typing", typing, key...)
+	if code != http.StatusTooManyRequests || header.Get("Retry-After") == "" {
+		t.Errorf("rate limited typing: %d, Retry-After %q, %v", code, header.Get("Retry-After"), out)
+	}
+
+	// The session cookie is passed on as the session header.
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "token"})
+	if md := requestMetadata(req); !slices.Equal(md.Get(sessionHeader), []string{"token"}) {
+		t.Errorf("metadata from the session cookie = %v", md)
+	}
+}
+
+func toStrings(v any) []string {
+	var out []string
+	for _, s := range v.([]any) {
+		out = append(out, s.(string))
+	}
+	return out
+}
+
+func TestConnect(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{"connect", []connect.ClientOption{connect.WithProtoJSON()}},
+		{"grpc-web", []connect.ClientOption{connect.WithGRPCWeb()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStore(defaultTunables())
+			defer s.stopCleanup()
+			base, client := serveGateway(t, s)
+			ctx := metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, "chat-key")
+
+			connected := connect.NewClient[pb.UserRequest, pb.OnlineUsersResponse](http.DefaultClient, base+pb.PresenceService_UserConnected_FullMethodName, tc.opts...)
+			_, err := connected.CallUnary(context.Background(), connect.NewRequest(&pb.UserRequest{Username: "alice"}))
+			if connect.CodeOf(err) != connect.CodePermissionDenied {
+				t.Fatalf("anonymous connect: expected PermissionDenied, got %v", err)
+			}
+			req := connect.NewRequest(&pb.UserRequest{Username: "alice", ConnectionId: "a1"})
+			req.Header().Set(apiKeyHeader, "chat-key")
+			resp, err := connected.CallUnary(context.Background(), req)
+			if err != nil || !slices.Equal(resp.Msg.Usernames, []string{"alice"}) {
+				t.Fatalf("connect alice: %v, %v", resp, err)
+			}
+			// Reads can be made with GET over Connect.
+			online := connect.NewClient[pb.OnlineUsersRequest, pb.OnlineUsersResponse](http.DefaultClient, base+pb.PresenceService_GetOnlineUsers_FullMethodName,
+				append(tc.opts, connect.WithHTTPGet(), connect.WithIdempotency(connect.IdempotencyNoSideEffects))...)
+			if resp, err = online.CallUnary(context.Background(), connect.NewRequest(&pb.OnlineUsersRequest{})); err != nil || !slices.Equal(resp.Msg.Usernames, []string{"alice"}) {
+				t.Fatalf("online users: %v, %v", resp, err)
+			}
+
+			watch := connect.NewClient[pb.WatchPresenceRequest, pb.PresenceEvent](http.DefaultClient, base+pb.PresenceService_WatchPresence_FullMethodName, tc.opts...)
+			watchCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, err := watch.CallServerStream(watchCtx, connect.NewRequest(&pb.WatchPresenceRequest{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer events.Close()
+			if !events.Receive() || events.Msg().Type != pb.PresenceEvent_SNAPSHOT || !slices.Equal(events.Msg().Online, []string{"alice"}) {
+				t.Fatalf("expected a snapshot with alice, got %v, %v", events.Msg(), events.Err())
+			}
+			if _, err := client.UserConnected(ctx, &pb.UserRequest{Username: "bob", ConnectionId: "b1"}); err != nil {
+				t.Fatal(err)
+			}
+			if !events.Receive() || events.Msg().Type != pb.PresenceEvent_ONLINE || events.Msg().Username != "bob" {
+				t.Fatalf("expected bob online, got %v, %v", events.Msg(), events.Err())
+			}
+
+			// The viewer is the user the gateway authenticated, whatever the request says.
+			if _, err := client.SetStatus(ctx, &pb.SetStatusRequest{Username: "bob", Status: pb.Status_INVISIBLE}); err != nil {
+				t.Fatal(err)
+			}
+			onlineReq := connect.NewRequest(&pb.OnlineUsersRequest{Viewer: "bob"})
+			onlineReq.Header().Set(authenticatedUserHeader, "alice")
+			if resp, err = online.CallUnary(context.Background(), onlineReq); err != nil || !slices.Equal(resp.Msg.Usernames, []string{"alice"}) {
+				t.Fatalf("online users for alice asking as bob: %v, %v", resp, err)
+			}
+			watchReq := connect.NewRequest(&pb.WatchPresenceRequest{Viewer: "bob"})
+			watchReq.Header().Set(authenticatedUserHeader, "alice")
+			hidden, err := watch.CallServerStream(watchCtx, watchReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hidden.Close()
+			if !hidden.Receive() || !slices.Equal(hidden.Msg().Online, []string{"alice"}) {
+				t.Fatalf("expected alice's snapshot without bob, got %v, %v", hidden.Msg(), hidden.Err())
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	base, client := serveGateway(t, s)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, "chat-key")
+	typing := func(username, room string, isTyping bool) {
+		t.Helper()
+		if _, err := client.SetTyping(ctx, &pb.SetTypingRequest{Username: username, Room: room, IsTyping: isTyping}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subscribe := func(lastEventID string) (*bufio.Reader, func()) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", base+"/presence/events?room=r1", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("subscribe: %s %s", resp.Status, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	// next returns the ID and data of the next event.
+	next := func(r *bufio.Reader) (string, map[string]any) {
+		t.Helper()
+		var id string
+		var data map[string]any
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && data != nil:
+				return id, data
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	events, stop := subscribe("")
+	if _, snap := next(events); snap["type"] != "SNAPSHOT" || snap["room"] != "r1" {
+		t.Fatalf("expected a snapshot of r1, got %v", snap)
+	}
+	typing("bob", "r2", true) // another room, filtered out
+	typing("alice", "r1", true)
+	id, ev := next(events)
+	if ev["type"] != "TYPING_STARTED" || ev["username"] != "alice" || ev["seq"] != id {
+		t.Fatalf("expected alice typing in r1 with seq %s, got %v", id, ev)
+	}
+	stop()
+
+	// A reconnecting client resumes after the last event it got.
+	typing("alice", "r1", false)
+	events, stop = subscribe(id)
+	defer stop()
+	id, ev = next(events)
+	if ev["type"] != "TYPING_STOPPED" || ev["username"] != "alice" {
+		t.Fatalf("expected the missed typing stop, got %v", ev)
+	}
+
+	// Resuming with nothing missed answers at once, before any event.
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timer := time.AfterFunc(2*time.Second, cancel)
+	req, _ := http.NewRequestWithContext(reqCtx, "GET", base+"/presence/events?room=r1", nil)
+	req.Header.Set("Last-Event-ID", id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected headers before the first event: %v", err)
+	}
+	timer.Stop()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("resume with nothing missed: %s", resp.Status)
+	}
+
+	resp, err = http.Get(base + "/presence/events?room=r1&viewer=alice&bogus=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown parameter: %s, want 400", resp.Status)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// caller is the authenticated identity of whoever made an RPC.
+type caller struct {
+	name    string // certificate common name, or its first DNS name, or the API key's name
+	subject string // full certificate subject
+	source  string // "mtls" or "api-key"
+}
+
+type callerKey struct{}
+
+// callerFrom returns the identity of the RPC's caller, if it was authenticated.
+func callerFrom(ctx context.Context) (caller, bool) {
+	c, ok := ctx.Value(callerKey{}).(caller)
+	return c, ok
+}
+
+// callerFromPeer reads the identity from a verified client certificate.
+func callerFromPeer(ctx context.Context) (caller, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return caller{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return caller{}, false
+	}
+	cert := info.State.VerifiedChains[0][0]
+	c := caller{name: cert.Subject.CommonName, subject: cert.Subject.String(), source: "mtls"}
+	if c.name == "" && len(cert.DNSNames) > 0 {
+		c.name = cert.DNSNames[0]
+	}
+	return c, true
+}
+
+// withCaller attaches the caller identity, if any, to ctx.
+func withCaller(ctx context.Context) context.Context {
+	if c, ok := callerFromPeer(ctx); ok {
+		return context.WithValue(ctx, callerKey{}, c)
+	}
+	return ctx
+}
+
+// identityUnaryInterceptor makes the caller identity available to handlers through callerFrom.
+func identityUnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withCaller(ctx), req)
+}
+
+// identityStreamInterceptor is identityUnaryInterceptor for streaming RPCs.
+func identityStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withCaller(ss.Context())})
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// contextHandler adds request-scoped attributes, the caller and the trace and
+// span IDs, to every record logged with a context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if c, ok := callerFrom(ctx); ok {
+		r.AddAttrs(slog.String("caller", c.name))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
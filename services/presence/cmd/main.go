@@ -1,57 +1,374 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
+// logLevel is the level of the default logger, set from the config.
+var logLevel slog.LevelVar
+
 func main() {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &logLevel})}))
+
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("invalid config", "error", err)
+		os.Exit(1)
+	}
+	logLevel.UnmarshalText([]byte(cfg.Log.Level)) // validate rejected unknown levels
+	slog.Info("effective config", "config", cfg.redacted())
 
-	port := env("PORT", "50051")
-	lis, err := net.Listen("tcp", ":"+port)
+	lis, err := net.Listen("tcp", cfg.Listen.GRPC)
 	if err != nil {
 		slog.Error("failed to listen", "error", err)
 		os.Exit(1)
 	}
 
-	store := newStore()
-	srv := grpc.NewServer()
-	pb.RegisterPresenceServiceServer(srv, &server{store: store})
+	store, err := newBackend(cfg.Store, cfg.Redis)
+	if err != nil {
+		slog.Error("failed to open store", "error", err)
+		os.Exit(1)
+	}
+	stop := make(chan struct{})
+	serverTLS, peerCreds, err := transportCredentials(cfg.TLS, stop)
+	if err != nil {
+		slog.Error("failed to load TLS credentials", "error", err)
+		os.Exit(1)
+	}
+	creds := insecure.NewCredentials()
+	if serverTLS != nil {
+		creds = credentials.NewTLS(serverTLS)
+	}
+	authz, err := newAuthorizerFromConfig(cfg.Auth)
+	if err != nil {
+		slog.Error("failed to load authorization policy", "error", err)
+		os.Exit(1)
+	}
+	limits := newRateLimitsFromConfig(cfg.RateLimit)
+	go limits.prune(time.Minute, stop)
+	tp, err := setupTracing(cfg.Tracing)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	unary := []grpc.UnaryServerInterceptor{metricsUnaryInterceptor, identityUnaryInterceptor, tracingUnaryInterceptor, authz.unaryInterceptor, limits.unaryInterceptor}
+	if sessions := newSessionValidatorFromConfig(cfg.Sessions, cfg.Redis, authz.audit); sessions != nil {
+		go sessions.prune(store, time.Minute, stop)
+		go sessions.watch(store, stop)
+		unary = append(unary, sessions.unaryInterceptor)
+	}
+	stream := []grpc.StreamServerInterceptor{metricsStreamInterceptor, identityStreamInterceptor, tracingStreamInterceptor, authz.streamInterceptor}
+	srv := grpc.NewServer(
+		grpc.Creds(creds),
+		tracingHandler(otel.GetTracerProvider()),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
+	presence := &server{store: store}
+	pb.RegisterPresenceServiceServer(srv, presence)
+	pb.RegisterPresenceAdminServer(srv, &adminServer{store: store, audit: authz.audit})
+	reflection.Register(srv)
+
+	cl, err := joinCluster(srv, store, cfg.Cluster, lis.Addr(), peerCreds)
+	if err != nil {
+		slog.Error("failed to join cluster", "error", err)
+		os.Exit(1)
+	}
 
 	healthSrv := health.NewServer()
 	healthpb.RegisterHealthServer(srv, healthSrv)
 	healthSrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
 
+	prometheus.MustRegister(storeCollector{store: store})
+	metricsSrv := serveMetrics(cfg.Listen.Metrics)
+
+	// The HTTP/JSON gateway gets its own listener, or takes over the gRPC one
+	// and hands gRPC requests to srv.
+	shared := cfg.Listen.HTTP == cfg.Listen.GRPC
+	var httpSrv *http.Server
+	switch gateway := newGateway(presence, unary, stream); {
+	case cfg.Listen.HTTP == "off":
+	case shared:
+		httpSrv = newHTTPServer(grpcOrHTTP(srv, gateway))
+	default:
+		httpLis, err := net.Listen("tcp", cfg.Listen.HTTP)
+		if err != nil {
+			slog.Error("failed to listen", "error", err)
+			os.Exit(1)
+		}
+		httpSrv = newHTTPServer(gateway)
+		go func() {
+			slog.Info("serving HTTP", "addr", httpLis.Addr().String())
+			if err := serveHTTP(httpSrv, httpLis, serverTLS); err != nil {
+				slog.Error("HTTP server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	go func() {
-		slog.Info("listening", "addr", ":"+port)
-		if err := srv.Serve(lis); err != nil {
+		slog.Info("listening", "addr", lis.Addr().String(), "http", shared)
+		var err error
+		if shared {
+			err = serveHTTP(httpSrv, lis, serverTLS)
+		} else {
+			err = srv.Serve(lis)
+		}
+		if err != nil {
 			slog.Error("server failed", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	rl := newReloader(cfg, os.Args[1:], os.Getenv, store, limits, authz)
+	go rl.watch(cfg.Reload.WatchInterval.Duration, stop)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			rl.reload("SIGHUP")
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-quit
 	slog.Info("shutting down", "signal", sig.String())
 
+	close(stop)
+	drain(cfg.Shutdown, healthSrv, store, srv, httpSrv, shared, quit)
+	// The snapshot is saved once no call can change the state any more.
+	if cl != nil {
+		cl.stop()
+	}
 	store.stopCleanup()
-	srv.GracefulStop()
+	if metricsSrv != nil {
+		metricsSrv.Close()
+	}
+	if tp != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := tp.Shutdown(ctx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+		cancel()
+	}
 	slog.Info("server stopped")
 }
 
-func env(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// setupTracing exports a span per RPC with c.Exporter: "otlp", "console" to
+// write them to c.File or stdout, or "none" to turn tracing off and return a
+// nil provider.
+func setupTracing(c tracingConfig) (*sdktrace.TracerProvider, error) {
+	if c.Exporter == "none" {
+		return nil, nil
+	}
+	out, err := traceOutput(c.File)
+	if err != nil {
+		return nil, fmt.Errorf("tracing.file: %w", err)
+	}
+	tp, err := newTracerProvider(context.Background(), c.Exporter, out)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	slog.Info("tracing enabled", "exporter", c.Exporter)
+	return tp, nil
+}
+
+// serveMetrics serves Prometheus metrics on /metrics at addr, unless addr is "off".
+func serveMetrics(addr string) *http.Server {
+	if addr == "off" {
+		return nil
 	}
-	return fallback
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		slog.Info("serving metrics", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("metrics server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+	return srv
+}
+
+// newBackend opens the store selected by c.Backend: "memory" keeps state in
+// this process, "redis" shares it between replicas. The memory store is
+// snapshotted to c.SnapshotPath, if set, so a restart does not take everyone
+// offline.
+func newBackend(c storeConfig, rc redisConfig) (backend, error) {
+	switch c.Backend {
+	case "memory":
+		s := newStore(c.tunables())
+		if c.SnapshotPath != "" {
+			if err := s.enableSnapshots(c.SnapshotPath, c.SnapshotInterval.Duration); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case "redis":
+		rdb := redisClient(rc)
+		slog.Info("using redis store", "addr", rdb.Options().Addr)
+		if c.SnapshotPath != "" {
+			slog.Warn("store.snapshot_path ignored, redis keeps state across restarts")
+		}
+		return newRedisStore(rdb, rc.Prefix, c.tunables())
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", c.Backend)
+	}
+}
+
+func redisClient(c redisConfig) *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: net.JoinHostPort(c.Host, c.Port), Password: c.Password})
+}
+
+// newSessionValidatorFromConfig requires write RPCs to carry the session token
+// of the user they act for when c.Validation is set. Sessions are looked up in
+// Redis under c.Prefix and cached for c.CacheTTL, and their connections dropped
+// when they end. It returns nil when validation is off.
+func newSessionValidatorFromConfig(c sessionsConfig, rc redisConfig, audit *slog.Logger) *sessionValidator {
+	if !c.Validation {
+		return nil
+	}
+	rdb := redisClient(rc)
+	slog.Info("validating sessions", "addr", rdb.Options().Addr, "prefix", c.Prefix, "cache_ttl", c.CacheTTL.Duration)
+	return newSessionValidator(rdb, c.Prefix, c.CacheTTL.Duration, audit)
+}
+
+// newRateLimitsFromConfig limits each user on every write RPC, and each caller
+// across them. A rate of 0 turns a limit off.
+func newRateLimitsFromConfig(c rateLimitConfig) *rateLimits {
+	return newRateLimits(newRateLimiter(c.User.Rate, c.User.Burst), newRateLimiter(c.Caller.Rate, c.Caller.Burst))
+}
+
+// transportCredentials serves TLS with c.CertFile and c.KeyFile when they are
+// set, and requires client certificates signed by c.ClientCAFile when that is
+// set too. Rotated files are picked up every c.ReloadInterval until stop is
+// closed. Without a certificate the listeners are plaintext and the server
+// config is nil. It returns the server config and the credentials for dialing
+// cluster peers, which speak TLS whenever the server does.
+func transportCredentials(c tlsConfig, stop <-chan struct{}) (server *tls.Config, peers credentials.TransportCredentials, err error) {
+	if c.CertFile == "" {
+		return nil, insecure.NewCredentials(), nil
+	}
+	r, err := newCertReloader(c.CertFile, c.KeyFile, c.ClientCAFile, c.PeerCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	go r.watch(c.ReloadInterval.Duration, stop)
+	slog.Info("serving TLS", "cert", c.CertFile, "client_auth", c.ClientCAFile != "")
+	return r.serverConfig(), credentials.NewTLS(r.clientConfig()), nil
+}
+
+// newAuthorizerFromConfig grants roles to the caller names, certificate common
+// names or API key names listed in c. Callers with none get c.AnonymousRole,
+// none by default. Roles are only enforced once one of the lists is set.
+// Denied calls are logged to c.AuditLog, or to stdout if unset.
+func newAuthorizerFromConfig(c authConfig) (*authorizer, error) {
+	p, err := newAuthPolicy(c)
+	if err != nil {
+		return nil, err
+	}
+	audit := slog.Default().With("log", "audit")
+	if c.AuditLog != "" {
+		f, err := os.OpenFile(c.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("auth.audit_log: %w", err)
+		}
+		audit = slog.New(contextHandler{slog.NewJSONHandler(f, nil)})
+	}
+	if p.enforce {
+		slog.Info("enforcing RPC roles", "callers", len(p.roles), "api_keys", len(p.apiKeys), "anonymous", p.anonymous.String())
+	}
+	return newAuthorizer(p, audit), nil
+}
+
+// newAuthPolicy builds the policy c describes, reading its API keys file.
+func newAuthPolicy(c authConfig) (*authPolicy, error) {
+	p := &authPolicy{roles: map[string]role{}}
+	for _, grant := range []struct {
+		names []string
+		role  role
+	}{{c.Readers, roleReader}, {c.Writers, roleWriter}, {c.Admins, roleAdmin}} {
+		for _, name := range grant.names {
+			p.roles[name] = max(p.roles[name], grant.role)
+			p.enforce = true
+		}
+	}
+	if c.AnonymousRole != "" {
+		r, err := parseRole(c.AnonymousRole)
+		if err != nil {
+			return nil, fmt.Errorf("auth.anonymous_role: %w", err)
+		}
+		p.anonymous = r
+	}
+	if c.APIKeysFile != "" {
+		keys, err := loadAPIKeys(c.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth.api_keys_file: %w", err)
+		}
+		p.apiKeys = keys
+	}
+	return p, nil
+}
+
+// joinCluster starts gossiping with the replicas in c.Peers, and serves the
+// gossip RPC on srv. It does nothing when there are no peers. The replica
+// advertises itself to peers as c.Advertise, by default its hostname and the
+// port of addr, the gRPC listener.
+func joinCluster(srv *grpc.Server, b backend, c clusterConfig, addr net.Addr, creds credentials.TransportCredentials) (*cluster, error) {
+	if len(c.Peers) == 0 {
+		return nil, nil
+	}
+	s, ok := b.(*store)
+	if !ok {
+		return nil, fmt.Errorf("cluster mode needs the memory store backend")
+	}
+	self := c.Advertise
+	if self == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		_, port, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil, err
+		}
+		self = net.JoinHostPort(host, port)
+	}
+
+	cl := newCluster(s, self, c.Peers, c.GossipInterval.Duration, c.PeerTimeout.Duration, creds)
+	pb.RegisterPresenceClusterServer(srv, cl)
+	cl.start()
+	slog.Info("joined cluster", "advertise", self, "peers", c.Peers)
+	return cl, nil
 }
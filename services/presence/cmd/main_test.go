@@ -2,23 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
 	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/alicebob/miniredis/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 func startTestServer(t *testing.T) pb.PresenceServiceClient {
+	t.Helper()
+	return serveBackend(t, newStore(defaultTunables()))
+}
+
+// serveBackend serves store on a local port and returns a client for it.
+func serveBackend(t *testing.T, store backend, opts ...grpc.ServerOption) pb.PresenceServiceClient {
 	t.Helper()
 	lis, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
 		t.Fatal(err)
 	}
-	srv := grpc.NewServer()
-	pb.RegisterPresenceServiceServer(srv, &server{store: newStore()})
+	srv := grpc.NewServer(opts...)
+	pb.RegisterPresenceServiceServer(srv, &server{store: store})
 	go srv.Serve(lis)
 	t.Cleanup(srv.GracefulStop)
 
@@ -59,7 +71,7 @@ func TestConnectDisconnect(t *testing.T) {
 	}
 
 	// Verify only bob remains
-	online, err := client.GetOnlineUsers(ctx, &pb.Empty{})
+	online, err := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -78,19 +90,116 @@ func TestMultipleConnections(t *testing.T) {
 
 	// Disconnect once — should still be online
 	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice"})
-	online, _ := client.GetOnlineUsers(ctx, &pb.Empty{})
+	online, _ := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
 	if len(online.Usernames) != 1 {
 		t.Fatalf("expected alice still online, got %v", online.Usernames)
 	}
 
 	// Disconnect again — now offline
 	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice"})
-	online, _ = client.GetOnlineUsers(ctx, &pb.Empty{})
+	online, _ = client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
 	if len(online.Usernames) != 0 {
 		t.Fatalf("expected no online users, got %v", online.Usernames)
 	}
 }
 
+func TestConnectionIDs(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1", Node: "chat-0", ClientType: "web"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2", Node: "chat-1", ClientType: "cli"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2", Node: "chat-1", ClientType: "cli"}) // retried
+
+	conns, err := client.GetUserConnections(ctx, &pb.UserRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conns.Connections) != 2 || conns.Connections[0].Id != "s1" || conns.Connections[1].Node != "chat-1" {
+		t.Fatalf("expected connections s1 and s2, got %v", conns.Connections)
+	}
+
+	// Duplicate disconnects for s1 must not take s2 down with it
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1"})
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1"})
+	online, _ := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
+	if len(online.Usernames) != 1 {
+		t.Fatalf("expected alice still online, got %v", online.Usernames)
+	}
+
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2"})
+	online, _ = client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
+	if len(online.Usernames) != 0 {
+		t.Fatalf("expected no online users, got %v", online.Usernames)
+	}
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s3"})
+	_, err = client.UserConnected(ctx, &pb.UserRequest{Username: "bob", ConnectionId: "s3"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for a connection owned by alice, got %v", err)
+	}
+}
+
+func TestLastSeen(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob"})
+	before := time.Now().UnixMilli()
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "bob"})
+
+	resp, err := client.GetLastSeen(ctx, &pb.UsersRequest{Usernames: []string{"alice", "bob", "carol"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol := resp.Users[0], resp.Users[1], resp.Users[2]
+	if !alice.Online {
+		t.Fatalf("expected alice online, got %v", alice)
+	}
+	if bob.Online || bob.LastSeen < before || bob.LastSeen > time.Now().UnixMilli() {
+		t.Fatalf("expected bob last seen just now, got %v", bob)
+	}
+	if carol.Online || carol.LastSeen != 0 {
+		t.Fatalf("expected carol never seen, got %v", carol)
+	}
+}
+
+func TestGetPresence(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1", Room: "d1"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "dave"})
+	client.SetTyping(ctx, &pb.SetTypingRequest{Username: "alice", Room: "d1", IsTyping: true})
+	client.SetStatus(ctx, &pb.SetStatusRequest{Username: "bob", Status: pb.Status_INVISIBLE})
+
+	resp, err := client.GetPresence(ctx, &pb.UsersRequest{Usernames: []string{"alice", "bob", "carol"}, Viewer: "dave"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 3 {
+		t.Fatalf("expected 3 users, got %v", resp.Users)
+	}
+	alice, bob, carol := resp.Users[0], resp.Users[1], resp.Users[2]
+	if !alice.Online || alice.ConnectionCount != 2 || !alice.Typing || len(alice.TypingRooms) != 1 || alice.TypingRooms[0] != "d1" {
+		t.Fatalf("unexpected presence for alice: %v", alice)
+	}
+	if bob.Online || bob.ConnectionCount != 0 || bob.Status.Status != pb.Status_ONLINE {
+		t.Fatalf("expected invisible bob to look offline, got %v", bob)
+	}
+	if carol.Online || carol.LastSeen != 0 {
+		t.Fatalf("expected carol offline, got %v", carol)
+	}
+
+	resp, _ = client.GetPresence(ctx, &pb.UsersRequest{Usernames: []string{"bob"}, Viewer: "bob"})
+	if !resp.Users[0].Online || resp.Users[0].Status.Status != pb.Status_INVISIBLE {
+		t.Fatalf("expected bob to see himself invisible, got %v", resp.Users[0])
+	}
+}
+
 func TestTyping(t *testing.T) {
 	client := startTestServer(t)
 	ctx := context.Background()
@@ -116,24 +225,454 @@ func TestTyping(t *testing.T) {
 	}
 }
 
+func TestRooms(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", Room: "d1"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob", Room: "d2"})
+
+	// Bob typing in d2 is invisible to d1
+	client.SetTyping(ctx, &pb.SetTypingRequest{Username: "bob", Room: "d2", IsTyping: true})
+	typing, _ := client.GetRoomTypingUsers(ctx, &pb.RoomRequest{Room: "d1"})
+	if len(typing.Usernames) != 0 {
+		t.Fatalf("expected nobody typing in d1, got %v", typing.Usernames)
+	}
+	typing, _ = client.GetRoomTypingUsers(ctx, &pb.RoomRequest{Room: "d2"})
+	if len(typing.Usernames) != 1 || typing.Usernames[0] != "bob" {
+		t.Fatalf("expected [bob] typing in d2, got %v", typing.Usernames)
+	}
+
+	// Bob switches to d1: leaving d2 clears his typing there
+	client.LeaveRoom(ctx, &pb.UserRequest{Username: "bob", Room: "d2"})
+	resp, err := client.JoinRoom(ctx, &pb.UserRequest{Username: "bob", Room: "d1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Usernames) != 2 {
+		t.Fatalf("expected [alice bob] in d1, got %v", resp.Usernames)
+	}
+	typing, _ = client.GetTypingUsers(ctx, &pb.Empty{})
+	if len(typing.Usernames) != 0 {
+		t.Fatalf("expected no typing users, got %v", typing.Usernames)
+	}
+
+	// Going offline leaves every room
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice"})
+	online, _ := client.GetRoomOnlineUsers(ctx, &pb.RoomRequest{Room: "d1"})
+	if len(online.Usernames) != 1 || online.Usernames[0] != "bob" {
+		t.Fatalf("expected [bob] in d1, got %v", online.Usernames)
+	}
+
+	_, err = client.JoinRoom(ctx, &pb.UserRequest{Username: "carol", Room: "d1"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for offline user, got %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	client := startTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob"})
+
+	_, err := client.SetStatus(ctx, &pb.SetStatusRequest{Username: "alice", Status: pb.Status_BUSY, CustomText: "in a meeting", Emoji: "📅"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	online, _ := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
+	if len(online.Users) != 2 || online.Users[0].Status != pb.Status_BUSY || online.Users[0].CustomText != "in a meeting" {
+		t.Fatalf("expected alice busy, got %v", online.Users)
+	}
+	if online.Users[1].Status != pb.Status_ONLINE {
+		t.Fatalf("expected bob online, got %v", online.Users[1])
+	}
+
+	stream, err := client.WatchPresence(ctx, &pb.WatchPresenceRequest{Viewer: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Recv() // snapshot
+
+	// Invisible looks offline to everyone but alice herself
+	client.SetStatus(ctx, &pb.SetStatusRequest{Username: "alice", Status: pb.Status_INVISIBLE})
+	online, _ = client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{Viewer: "bob"})
+	if len(online.Usernames) != 1 || online.Usernames[0] != "bob" {
+		t.Fatalf("expected [bob] for bob, got %v", online.Usernames)
+	}
+	online, _ = client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{Viewer: "alice"})
+	if len(online.Usernames) != 2 || online.Users[0].Status != pb.Status_INVISIBLE {
+		t.Fatalf("expected alice to see herself invisible, got %v", online.Users)
+	}
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != pb.PresenceEvent_OFFLINE || ev.Username != "alice" {
+		t.Fatalf("expected bob to see alice go offline, got %v", ev)
+	}
+
+	// Reconnecting while invisible stays hidden; becoming visible comes online
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice"})
+	client.SetStatus(ctx, &pb.SetStatusRequest{Username: "alice", Status: pb.Status_AWAY})
+	for _, typ := range []pb.PresenceEvent_Type{pb.PresenceEvent_ONLINE, pb.PresenceEvent_STATUS_CHANGED} {
+		ev, err = stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != typ || ev.Username != "alice" {
+			t.Fatalf("expected %v for alice, got %v", typ, ev)
+		}
+	}
+	if ev.Status.GetStatus() != pb.Status_AWAY {
+		t.Fatalf("expected away status, got %v", ev.Status)
+	}
+}
+
+func TestInvisibleTyping(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	s.connect(connection{username: "alice"}, "d1")
+	s.connect(connection{username: "bob"}, "d1")
+	s.setTyping("alice", "d1", true)
+	_, events, cancel, _ := s.watch("d1", "bob")
+	defer cancel()
+
+	// Going invisible stops alice's typing for others, and hides it from then on
+	s.setStatus(userStatus{username: "alice", status: statusInvisible})
+	for _, typ := range []eventType{eventTypingStopped, eventLeft} {
+		if ev := <-events; ev.typ != typ || ev.username != "alice" {
+			t.Fatalf("expected bob to see alice stop typing and leave, got %+v", ev)
+		}
+	}
+	if users, _ := s.roomTypingUsers("d1", "bob"); len(users) != 0 {
+		t.Fatalf("expected alice's typing hidden from bob, got %v", users)
+	}
+	if users, _ := s.typingUsers(""); len(users) != 0 {
+		t.Fatalf("expected alice's typing hidden, got %v", users)
+	}
+	if users, _ := s.roomTypingUsers("d1", "alice"); len(users) != 1 {
+		t.Fatalf("expected alice to see herself typing, got %v", users)
+	}
+	if snap, _, cancel, _ := s.watch("d1", "bob"); len(snap.typing) != 0 {
+		t.Fatalf("expected no typing in bob's snapshot, got %v", snap.typing)
+	} else {
+		cancel()
+	}
+
+	s.setTyping("alice", "d1", false)
+	s.setTyping("bob", "d1", true)
+	if ev := <-events; ev.typ != eventTypingStarted || ev.username != "bob" {
+		t.Fatalf("expected only bob's own typing, got %+v", ev)
+	}
+}
+
+func TestRoomStatus(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		s := newStore(defaultTunables())
+		defer s.stopCleanup()
+		testRoomStatus(t, s)
+	})
+	t.Run("redis", func(t *testing.T) {
+		testRoomStatus(t, newTestRedisStore(t, miniredis.RunT(t)))
+	})
+}
+
+func testRoomStatus(t *testing.T, store backend) {
+	store.connect(connection{id: "s1", username: "alice"}, "r1")
+	_, events, cancel, _ := store.watch("r1", "bob")
+	defer cancel()
+	_, other, cancelOther, _ := store.watch("r2", "bob")
+	defer cancelOther()
+
+	// A status change reaches watchers of the user's rooms, and only those
+	store.setStatus(userStatus{username: "alice", status: statusAway})
+	select {
+	case ev := <-events:
+		if ev.typ != eventStatusChanged || ev.username != "alice" || ev.status.status != statusAway {
+			t.Fatalf("expected alice's status change in r1, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected alice's status change in r1")
+	}
+	select {
+	case ev := <-other:
+		t.Fatalf("expected nothing in r2, got %+v", ev)
+	default:
+	}
+}
+
+func TestStatusExpiry(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	s.connect(connection{username: "alice"}, "")
+	s.setStatus(userStatus{username: "alice", status: statusBusy, text: "focus", expiresAt: time.Now().Add(-time.Second)})
+
+	s.expireStatuses()
+	if sts, _ := s.userStatuses([]string{"alice"}); !sts[0].isDefault() {
+		t.Fatalf("expected status reset to online, got %+v", sts[0])
+	}
+}
+
 func TestTypingExpiry(t *testing.T) {
-	s := newStore()
-	s.connect("alice")
-	s.setTyping("alice", true)
+	s := newStore(defaultTunables())
+	s.connect(connection{username: "alice"}, "")
+	s.setTyping("alice", "", true)
 
-	if len(s.typingUsers()) != 1 {
+	if users, _ := s.typingUsers(""); len(users) != 1 {
 		t.Fatal("expected alice typing")
 	}
 
 	// Manually backdate the typing timestamp
 	s.mu.Lock()
-	s.typing["alice"] = time.Now().Add(-9 * time.Second)
+	s.typing[typingKey{username: "alice"}] = time.Now().Add(-9 * time.Second)
 	s.mu.Unlock()
 
 	// Wait for cleanup tick
 	time.Sleep(1500 * time.Millisecond)
 
-	if len(s.typingUsers()) != 0 {
+	if users, _ := s.typingUsers(""); len(users) != 0 {
 		t.Fatal("expected typing to have expired")
 	}
 }
+
+func TestWatchPresence(t *testing.T) {
+	client := startTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice"})
+
+	stream, err := client.WatchPresence(ctx, &pb.WatchPresenceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Type != pb.PresenceEvent_SNAPSHOT || len(snap.Online) != 1 || snap.Online[0] != "alice" {
+		t.Fatalf("expected snapshot with [alice], got %v", snap)
+	}
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob"}) // second tab, no event
+	client.SetTyping(ctx, &pb.SetTypingRequest{Username: "bob", IsTyping: true})
+	client.SetTyping(ctx, &pb.SetTypingRequest{Username: "bob", IsTyping: true}) // refresh, no event
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "bob"})
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "bob"})
+
+	want := []pb.PresenceEvent_Type{
+		pb.PresenceEvent_ONLINE,
+		pb.PresenceEvent_TYPING_STARTED,
+		pb.PresenceEvent_TYPING_STOPPED,
+		pb.PresenceEvent_OFFLINE,
+	}
+	for _, typ := range want {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != typ || ev.Username != "bob" {
+			t.Fatalf("expected %v for bob, got %v", typ, ev)
+		}
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+	watch := func(req *pb.WatchPresenceRequest) (pb.PresenceService_WatchPresenceClient, context.CancelFunc) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := client.WatchPresence(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stream, cancel
+	}
+
+	stream, cancel := watch(&pb.WatchPresenceRequest{})
+	snap, err := stream.Recv()
+	if err != nil || snap.Seq == 0 {
+		t.Fatalf("expected a snapshot with a seq, got %v, %v", snap, err)
+	}
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice"})
+	online, err := stream.Recv()
+	if err != nil || online.Seq != snap.Seq+1 {
+		t.Fatalf("expected alice online after the snapshot, got %v, %v", online, err)
+	}
+	cancel()
+
+	// Changes made while away are replayed after the last event seen.
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob"})
+	client.SetTyping(ctx, &pb.SetTypingRequest{Username: "bob", IsTyping: true, Room: "r1"})
+	stream, cancel = watch(&pb.WatchPresenceRequest{ResumeAfter: proto.Uint64(online.Seq)})
+	defer cancel()
+	for _, typ := range []pb.PresenceEvent_Type{pb.PresenceEvent_ONLINE, pb.PresenceEvent_TYPING_STARTED} {
+		ev, err := stream.Recv()
+		if err != nil || ev.Type != typ || ev.Username != "bob" {
+			t.Fatalf("expected %v for bob, got %v, %v", typ, ev, err)
+		}
+	}
+
+	// A seq this server never published gets a fresh snapshot.
+	stream, cancel = watch(&pb.WatchPresenceRequest{Room: "r1", ResumeAfter: proto.Uint64(online.Seq + 100)})
+	defer cancel()
+	if ev, err := stream.Recv(); err != nil || ev.Type != pb.PresenceEvent_SNAPSHOT || len(ev.Typing) != 1 {
+		t.Fatalf("expected a snapshot of r1 with bob typing, got %v, %v", ev, err)
+	}
+}
+
+func TestWatchTypingExpiry(t *testing.T) {
+	s := newStore(defaultTunables())
+	s.connect(connection{username: "alice"}, "")
+	s.setTyping("alice", "", true)
+
+	snap, events, cancel, _ := s.watch("", "")
+	defer cancel()
+	if len(snap.typing) != 1 {
+		t.Fatalf("expected alice in snapshot, got %v", snap.typing)
+	}
+
+	s.mu.Lock()
+	s.typing[typingKey{username: "alice"}] = time.Now().Add(-9 * time.Second)
+	s.mu.Unlock()
+	s.cleanupExpiredTyping()
+
+	select {
+	case ev := <-events:
+		if ev.typ != eventTypingExpired || ev.username != "alice" {
+			t.Fatalf("expected typing expired for alice, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected typing expired event")
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	s.leaseTTL = 50 * time.Millisecond
+
+	s.connect(connection{id: "s1", username: "alice"}, "")
+	s.connect(connection{username: "bob"}, "") // anonymous, no lease
+
+	_, events, cancel, _ := s.watch("", "")
+	defer cancel()
+
+	// A heartbeat keeps the lease alive past its original deadline
+	time.Sleep(30 * time.Millisecond)
+	if _, err := s.heartbeat("alice", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	s.reapExpiredLeases()
+	if users, _ := s.onlineUsers(""); len(users) != 2 {
+		t.Fatalf("expected alice still online, got %v", users)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	s.reapExpiredLeases()
+	if users, _ := s.onlineUsers(""); len(users) != 1 || users[0] != "bob" {
+		t.Fatalf("expected [bob], got %v", users)
+	}
+	if ev := <-events; ev.typ != eventOffline || ev.username != "alice" {
+		t.Fatalf("expected offline event for alice, got %+v", ev)
+	}
+
+	if _, err := s.heartbeat("alice", "s1"); !errors.Is(err, errUnknownConnection) {
+		t.Fatalf("expected errUnknownConnection after expiry, got %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "presence.json")
+	s := newStore(defaultTunables())
+	s.connect(connection{id: "s1", username: "alice", node: "chat-0"}, "d1")
+	s.connect(connection{id: "s2", username: "bob"}, "")
+	s.connect(connection{username: "carol"}, "") // anonymous, not saved
+	s.setStatus(userStatus{username: "alice", status: statusAway, text: "lunch"})
+	s.disconnect("bob", "s2")
+	if err := s.saveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	s.stopCleanup()
+
+	r := newStore(defaultTunables())
+	defer r.stopCleanup()
+	if err := r.restoreSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if users, _ := r.roomOnlineUsers("d1", ""); len(users) != 1 || users[0] != "alice" {
+		t.Fatalf("expected alice restored in d1, got %v", users)
+	}
+	if users, _ := r.onlineUsers(""); len(users) != 1 {
+		t.Fatalf("expected only alice online, got %v", users)
+	}
+	if sts, _ := r.userStatuses([]string{"alice"}); sts[0].status != statusAway || sts[0].text != "lunch" {
+		t.Fatalf("expected alice's status restored, got %+v", sts[0])
+	}
+	if seen, _ := r.lastSeenUsers([]string{"bob"}); seen[0].at.IsZero() {
+		t.Fatal("expected bob's last-seen time restored")
+	}
+
+	conns, _ := r.userConnections("alice")
+	if len(conns) != 1 || !conns[0].provisional || conns[0].node != "chat-0" {
+		t.Fatalf("expected a provisional connection, got %+v", conns)
+	}
+	r.heartbeat("alice", "s1")
+	if conns, _ := r.userConnections("alice"); conns[0].provisional {
+		t.Fatal("expected heartbeat to confirm the connection")
+	}
+}
+
+func TestPurgeNode(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1", Node: "chat-0"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2", Node: "chat-1"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob", ConnectionId: "s3", Node: "chat-0"})
+
+	if _, err := client.PurgeNode(ctx, &pb.NodeRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument without a node, got %v", err)
+	}
+	resp, err := client.PurgeNode(ctx, &pb.NodeRequest{Node: "chat-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Connections != 2 {
+		t.Fatalf("expected 2 connections purged, got %d", resp.Connections)
+	}
+	online, _ := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
+	if len(online.Usernames) != 1 || online.Usernames[0] != "alice" {
+		t.Fatalf("expected alice still online through chat-1, got %v", online.Usernames)
+	}
+}
+
+func TestNodeExpiry(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	s.leaseTTL = 50 * time.Millisecond
+
+	s.registerNode("chat-0")
+	s.connect(connection{username: "alice", node: "chat-0"}, "") // anonymous connections have no lease of their own
+	s.connect(connection{username: "bob", node: "chat-1"}, "")   // unregistered node, never purged
+
+	time.Sleep(30 * time.Millisecond)
+	s.registerNode("chat-0")
+	time.Sleep(30 * time.Millisecond)
+	s.reapExpiredNodes()
+	if users, _ := s.onlineUsers(""); len(users) != 2 {
+		t.Fatalf("expected renewed node to keep alice online, got %v", users)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	s.reapExpiredNodes()
+	if users, _ := s.onlineUsers(""); len(users) != 1 || users[0] != "bob" {
+		t.Fatalf("expected [bob], got %v", users)
+	}
+}
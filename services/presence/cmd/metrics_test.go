@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newStore(defaultTunables())
+	t.Cleanup(s.stopCleanup)
+	client := serveBackend(t, s, grpc.ChainUnaryInterceptor(metricsUnaryInterceptor))
+	ctx := context.Background()
+	connects, disconnects := testutil.ToFloat64(connectsTotal), testutil.ToFloat64(disconnectsTotal)
+	expirations := testutil.ToFloat64(typingExpirationsTotal)
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "bob", ConnectionId: "s3"})
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s2"})
+	client.SetTyping(ctx, &pb.SetTypingRequest{Username: "bob", IsTyping: true})
+	client.Heartbeat(ctx, &pb.HeartbeatRequest{Username: "carol", ConnectionId: "s9"})
+
+	err := testutil.CollectAndCompare(storeCollector{store: s}, strings.NewReader(`
+# HELP presence_connections Registered connections.
+# TYPE presence_connections gauge
+presence_connections 2
+# HELP presence_online_users Users with at least one connection.
+# TYPE presence_online_users gauge
+presence_online_users 2
+# HELP presence_typing_users Typing statuses, one per user and room.
+# TYPE presence_typing_users gauge
+presence_typing_users 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(connectsTotal) - connects; got != 3 {
+		t.Fatalf("expected 3 connects, got %v", got)
+	}
+	if got := testutil.ToFloat64(disconnectsTotal) - disconnects; got != 1 {
+		t.Fatalf("expected 1 disconnect, got %v", got)
+	}
+	if got := testutil.ToFloat64(rpcsTotal.WithLabelValues(pb.PresenceService_Heartbeat_FullMethodName, "NotFound")); got != 1 {
+		t.Fatalf("expected the failed heartbeat counted as NotFound, got %v", got)
+	}
+
+	s.mu.Lock()
+	s.typing[typingKey{username: "bob"}] = time.Now().Add(-2 * s.typingTimeout)
+	s.mu.Unlock()
+	s.cleanupExpiredTyping()
+	if got := testutil.ToFloat64(typingExpirationsTotal) - expirations; got != 1 {
+		t.Fatalf("expected 1 typing expiration, got %v", got)
+	}
+}
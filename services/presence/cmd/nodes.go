@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// registerNode registers a chat node, or renews its lease, and returns the new
+// deadline. Chat nodes renew it like connection leases.
+func (s *store) registerNode(node string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nodes[node]; !ok {
+		slog.Info("chat node registered", "node", node)
+	}
+	s.nodes[node] = time.Now().Add(s.leaseTTL)
+	return s.nodes[node], nil
+}
+
+// purgeNode forgets a chat node and drops every connection tagged with it,
+// emitting the same transitions as explicit disconnects. It returns how many
+// connections were dropped. In cluster mode only connections registered through
+// this replica are dropped; their own replicas drop the others.
+func (s *store) purgeNode(node string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.purgeNodeLocked(node, time.Now()), nil
+}
+
+// purgeNodeLocked does purgeNode. Caller must hold s.mu.
+func (s *store) purgeNodeLocked(node string, now time.Time) int {
+	delete(s.nodes, node)
+	n := 0
+	for _, c := range s.conns {
+		if c.node == node && c.origin == "" {
+			s.removeConnLocked(c, now)
+			n++
+		}
+	}
+	return n
+}
+
+// reapExpiredNodes purges chat nodes that stopped renewing their lease, e.g.
+// because their pod crashed or was evicted.
+func (s *store) reapExpiredNodes() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for node, deadline := range s.nodes {
+		if now.After(deadline) {
+			n := s.purgeNodeLocked(node, now)
+			slog.Warn("chat node lease expired", "node", node, "connections", n)
+		}
+	}
+	return nil
+}
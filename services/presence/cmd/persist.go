@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// savedState is the on-disk form of a store snapshot. Typing is not saved: it
+// lasts seconds and would have expired by the time a new process restores it.
+type savedState struct {
+	SavedAt     int64                  `json:"saved_at"`
+	Connections []savedConnection      `json:"connections"`
+	Rooms       map[string][]string    `json:"rooms,omitempty"` // room -> members
+	Statuses    map[string]*wireStatus `json:"statuses,omitempty"`
+	LastSeen    map[string]int64       `json:"last_seen,omitempty"`
+}
+
+type savedConnection struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Node        string `json:"node,omitempty"`
+	ClientType  string `json:"client_type,omitempty"`
+	ConnectedAt int64  `json:"connected_at"`
+}
+
+// enableSnapshots restores the snapshot at path, if there is one, then saves a
+// new one every interval and once more on stopCleanup.
+func (s *store) enableSnapshots(path string, interval time.Duration) error {
+	if err := s.restoreSnapshot(path); err != nil {
+		return err
+	}
+	s.snapshotPath = path
+	go runSweep(interval, s.cleanupDone, func() {
+		if err := s.saveSnapshot(path); err != nil {
+			slog.Warn("failed to save snapshot", "path", path, "error", err)
+		}
+	})
+	return nil
+}
+
+// saveSnapshot writes connections, room membership, statuses and last-seen
+// times to path, replacing it atomically. Anonymous connections are skipped:
+// without an ID their owner could never confirm them after a restart. So is
+// state gossiped by cluster peers, which they gossip again after a restart.
+func (s *store) saveSnapshot(path string) error {
+	s.mu.RLock()
+	state := savedState{
+		SavedAt:  time.Now().UnixMilli(),
+		Rooms:    make(map[string][]string, len(s.rooms)),
+		Statuses: make(map[string]*wireStatus, len(s.statuses)),
+		LastSeen: make(map[string]int64, len(s.lastSeen)),
+	}
+	for _, c := range s.conns {
+		if c.anonymous || c.origin != "" {
+			continue
+		}
+		state.Connections = append(state.Connections, savedConnection{
+			ID:          c.id,
+			Username:    c.username,
+			Node:        c.node,
+			ClientType:  c.clientType,
+			ConnectedAt: c.connectedAt.UnixMilli(),
+		})
+	}
+	for room, members := range s.rooms {
+		for u, from := range members {
+			if _, ok := from[""]; ok {
+				state.Rooms[room] = append(state.Rooms[room], u)
+			}
+		}
+	}
+	for u, st := range s.statuses {
+		state.Statuses[u] = toWireStatus(st)
+	}
+	for u, t := range s.lastSeen {
+		state.LastSeen[u] = t.UnixMilli()
+	}
+	s.mu.RUnlock()
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreSnapshot loads the snapshot at path into an empty store. A missing
+// file is not an error. Restored connections are provisional: they get a fresh
+// lease, and unless a heartbeat or reconnect confirms them before it runs out
+// they are reaped like any other expired connection.
+func (s *store) restoreSnapshot(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("decode snapshot %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, sc := range state.Connections {
+		c := &connection{
+			id:          sc.ID,
+			username:    sc.Username,
+			node:        sc.Node,
+			clientType:  sc.ClientType,
+			connectedAt: time.UnixMilli(sc.ConnectedAt),
+			expiresAt:   now.Add(s.leaseTTL),
+			provisional: true,
+		}
+		s.conns[c.id] = c
+		if s.online[c.username] == nil {
+			s.online[c.username] = make(map[string]*connection)
+		}
+		s.online[c.username][c.id] = c
+	}
+	for room, members := range state.Rooms {
+		for _, u := range members {
+			if _, ok := s.online[u]; !ok {
+				continue // only had anonymous connections
+			}
+			if s.rooms[room] == nil {
+				s.rooms[room] = make(map[string]origins)
+			}
+			s.rooms[room][u] = origins{"": {}}
+		}
+	}
+	for u, ws := range state.Statuses {
+		if st := ws.userStatus(u); st.expiresAt.IsZero() || now.Before(st.expiresAt) {
+			s.statuses[u] = st
+		}
+	}
+	for u, ms := range state.LastSeen {
+		s.lastSeen[u] = time.UnixMilli(ms)
+	}
+	slog.Info("restored snapshot", "path", path, "saved_at", time.UnixMilli(state.SavedAt), "connections", len(state.Connections))
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// rateLimitedMethods are the PresenceService RPCs that need the writer role.
+func rateLimitedMethods() map[string]bool {
+	methods := map[string]bool{}
+	for m, r := range methodRoles {
+		if r == roleWriter && strings.HasPrefix(m, "/presence.PresenceService/") {
+			methods[m] = true
+		}
+	}
+	return methods
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of token buckets refilled at rate tokens per second, up to burst.
+type rateLimiter struct {
+	rate, burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter returns nil, which allows everything, if rate is not positive.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, burst: float64(max(burst, 1)), buckets: map[string]*tokenBucket{}}
+}
+
+// take takes a token from key's bucket. If it is empty, it returns false and
+// how long until a token is available.
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have refilled, which behave like new ones.
+func (l *rateLimiter) prune(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimits limits write RPCs per end user, for each method separately, and
+// per caller across all of them. The limiters are swapped when the config is
+// reloaded.
+type rateLimits struct {
+	methods map[string]bool
+	user    atomic.Pointer[rateLimiter] // keyed by method and username
+	caller  atomic.Pointer[rateLimiter] // keyed by caller name, or peer IP if anonymous
+
+	rejected atomic.Int64 // since the last prune
+}
+
+func newRateLimits(user, caller *rateLimiter) *rateLimits {
+	r := &rateLimits{methods: rateLimitedMethods()}
+	r.user.Store(user)
+	r.caller.Store(caller)
+	return r
+}
+
+// rateLimitCaller identifies the caller for rate limiting.
+func rateLimitCaller(ctx context.Context) string {
+	if c, ok := callerFrom(ctx); ok {
+		return c.name
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// unaryInterceptor rejects calls over either limit with ResourceExhausted and
+// the delay to retry after.
+func (r *rateLimits) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !r.methods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	// Users are checked first, so that one user's calls over their limit do
+	// not use up the caller's limit, shared by everyone it calls for.
+	now := time.Now()
+	if u, isUser := req.(interface{ GetUsername() string }); isUser {
+		if ok, wait := r.user.Load().take(info.FullMethod+"\x00"+u.GetUsername(), now); !ok {
+			return nil, r.reject(ctx, info.FullMethod, "user", wait, "username", u.GetUsername())
+		}
+	}
+	if ok, wait := r.caller.Load().take(rateLimitCaller(ctx), now); !ok {
+		return nil, r.reject(ctx, info.FullMethod, "caller", wait)
+	}
+	return handler(ctx, req)
+}
+
+func (r *rateLimits) reject(ctx context.Context, method, scope string, wait time.Duration, attrs ...any) error {
+	rateLimitedTotal.WithLabelValues(method, scope).Inc()
+	r.rejected.Add(1)
+	slog.DebugContext(ctx, "rate limited", append([]any{"method", method, "scope", scope}, attrs...)...)
+	st, err := status.New(codes.ResourceExhausted, scope+" rate limit exceeded").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, scope+" rate limit exceeded")
+	}
+	return st.Err()
+}
+
+// prune drops idle buckets every interval until done is closed, and logs how
+// many calls were rejected in the meantime.
+func (r *rateLimits) prune(interval time.Duration, done <-chan struct{}) {
+	runSweep(interval, done, func() {
+		now := time.Now()
+		r.user.Load().prune(now)
+		r.caller.Load().prune(now)
+		if n := r.rejected.Swap(0); n > 0 {
+			slog.Warn("rate limited calls", "rejected", n, "interval", interval)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimit(t *testing.T) {
+	limits := newRateLimits(newRateLimiter(1, 3), newRateLimiter(1, 5))
+	client := serveBackend(t, newStore(defaultTunables()), grpc.ChainUnaryInterceptor(limits.unaryInterceptor))
+	ctx := context.Background()
+
+	typing := func(username string) error {
+		_, err := client.SetTyping(ctx, &pb.SetTypingRequest{Username: username, IsTyping: true})
+		return err
+	}
+	for range 3 {
+		if err := typing("alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := typing("alice")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected alice's 4th call to be limited, got %v", err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		retry, _ = d.(*errdetails.RetryInfo)
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() <= 0 || retry.RetryDelay.AsDuration() > time.Second {
+		t.Fatalf("expected a retry delay of up to 1s, got %v", retry)
+	}
+
+	// Other methods and users have their own buckets, but share the caller's
+	if _, err := client.UserConnected(ctx, &pb.UserRequest{Username: "alice"}); err != nil {
+		t.Fatalf("expected alice to still connect, got %v", err)
+	}
+	if err := typing("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := typing("carol"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the caller's limit to be reached, got %v", err)
+	}
+	if _, err := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{}); err != nil {
+		t.Fatalf("expected reads not to be limited, got %v", err)
+	}
+	if got := testutil.ToFloat64(rateLimitedTotal.WithLabelValues(pb.PresenceService_SetTyping_FullMethodName, "user")); got == 0 {
+		t.Fatal("expected the rejection to be counted")
+	}
+}
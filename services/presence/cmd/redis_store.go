@@ -0,0 +1,1246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// errTxnConflict is returned when a transaction keeps losing races with other replicas.
+var errTxnConflict = errors.New("too many concurrent presence updates, retry")
+
+const (
+	redisTimeout    = 2 * time.Second
+	redisTxnRetries = 10
+)
+
+// redisStore keeps presence state in Redis so several replicas can share it.
+// Every mutation is an optimistic transaction (WATCH/MULTI/EXEC) that also
+// publishes its transitions, numbered by a shared counter, on a pub/sub
+// channel. Each replica relays that channel to its own watchers, so a watcher
+// sees every change whichever replica made it.
+type redisStore struct {
+	rdb  *redis.Client
+	keys redisKeys
+	tunables
+
+	mu      sync.Mutex // guards hub, lastSeq and typingTimeout
+	hub     hub
+	lastSeq uint64 // last event relayed from the channel
+
+	pubsub      *redis.PubSub
+	cleanupDone chan struct{}
+}
+
+var _ backend = (*redisStore)(nil)
+
+// publishScript numbers and publishes events: KEYS = {seq, channel}, ARGV = payloads.
+var publishScript = `
+for i = 1, #ARGV do
+  local seq = redis.call('INCR', KEYS[1])
+  redis.call('PUBLISH', KEYS[2], seq .. ' ' .. ARGV[i])
+end
+return #ARGV`
+
+// redisKeys names every key under a common prefix.
+type redisKeys struct{ prefix string }
+
+func (k redisKeys) conn(id string) string        { return k.prefix + "conn:" + id }
+func (k redisKeys) userConns(user string) string { return k.prefix + "user:" + user + ":conns" }
+func (k redisKeys) userRooms(user string) string { return k.prefix + "user:" + user + ":rooms" }
+func (k redisKeys) status(user string) string    { return k.prefix + "status:" + user }
+func (k redisKeys) room(room string) string      { return k.prefix + "room:" + room }
+func (k redisKeys) nodeConns(node string) string { return k.prefix + "node:" + node + ":conns" }
+func (k redisKeys) nodes() string                { return k.prefix + "nodes" }         // zset of chat nodes by lease expiry ms
+func (k redisKeys) online() string               { return k.prefix + "online" }        // set of usernames
+func (k redisKeys) typing() string               { return k.prefix + "typing" }        // zset of typing members by ms
+func (k redisKeys) leases() string               { return k.prefix + "leases" }        // zset of connection IDs by expiry ms
+func (k redisKeys) statusExpiry() string         { return k.prefix + "status-expiry" } // zset of usernames by expiry ms
+func (k redisKeys) lastSeen() string             { return k.prefix + "last-seen" }     // hash of username -> ms
+func (k redisKeys) anonSeq() string              { return k.prefix + "anon-seq" }
+func (k redisKeys) seq() string                  { return k.prefix + "seq" }
+func (k redisKeys) events() string               { return k.prefix + "events" }
+
+// typingMember encodes a typing key as a zset member.
+func typingMember(k typingKey) string { return k.room + "\x1f" + k.username }
+
+func parseTypingMember(m string) typingKey {
+	room, username, _ := strings.Cut(m, "\x1f")
+	return typingKey{room: room, username: username}
+}
+
+// newRedisStore connects to Redis, subscribes to the event channel and starts
+// the background sweeps. Every replica sweeps; transactions make that safe.
+func newRedisStore(rdb *redis.Client, prefix string, t tunables) (*redisStore, error) {
+	r := &redisStore{
+		rdb:         rdb,
+		keys:        redisKeys{prefix: prefix},
+		tunables:    t,
+		hub:         newHub(),
+		cleanupDone: make(chan struct{}),
+	}
+
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	r.pubsub = rdb.Subscribe(context.Background(), r.keys.events())
+	if _, err := r.pubsub.Receive(ctx); err != nil {
+		r.pubsub.Close()
+		return nil, fmt.Errorf("redis subscribe: %w", err)
+	}
+
+	go r.relayEvents()
+	go runSweep(r.sweepInterval, r.cleanupDone, func() {
+		for _, sweep := range []func() error{r.cleanupExpiredTyping, r.reapExpiredLeases, r.reapExpiredNodes, r.expireStatuses} {
+			if err := sweep(); err != nil {
+				slog.Warn("redis sweep failed", "error", err)
+			}
+		}
+	})
+	return r, nil
+}
+
+func (r *redisStore) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisTimeout)
+}
+
+// txn runs fn as an optimistic transaction watching keys, retrying when another
+// replica changes them first. fn does its reads through tx and its writes in
+// tx.TxPipelined, which fails if a watched key changed in between.
+func (r *redisStore) txn(fn func(ctx context.Context, tx *redis.Tx) error, keys ...string) error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	for range redisTxnRetries {
+		err := r.rdb.Watch(ctx, func(tx *redis.Tx) error { return fn(ctx, tx) }, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return errTxnConflict
+}
+
+// wireEvent is an event as published on the Redis channel.
+type wireEvent struct {
+	Type     eventType   `json:"t"`
+	Username string      `json:"u"`
+	Room     string      `json:"r,omitempty"`
+	At       int64       `json:"at"`
+	Audience audience    `json:"a,omitempty"`
+	Status   *wireStatus `json:"s,omitempty"`
+	Rooms    []string    `json:"rs,omitempty"`
+}
+
+// wireStatus is a userStatus as stored and published in Redis.
+type wireStatus struct {
+	Status    presenceStatus `json:"status"`
+	Text      string         `json:"text,omitempty"`
+	Emoji     string         `json:"emoji,omitempty"`
+	ExpiresAt int64          `json:"expires_at,omitempty"`
+}
+
+func toWireStatus(st userStatus) *wireStatus {
+	return &wireStatus{Status: st.status, Text: st.text, Emoji: st.emoji, ExpiresAt: unixMilli(st.expiresAt)}
+}
+
+func (ws *wireStatus) userStatus(username string) userStatus {
+	st := userStatus{username: username, status: ws.Status, text: ws.Text, emoji: ws.Emoji}
+	if ws.ExpiresAt > 0 {
+		st.expiresAt = time.UnixMilli(ws.ExpiresAt)
+	}
+	return st
+}
+
+// unixMilli is t in unix milliseconds, or 0 for the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// publish queues evs on pipe so they go out with the transaction's writes.
+func (r *redisStore) publish(ctx context.Context, pipe redis.Pipeliner, evs []event) {
+	if len(evs) == 0 {
+		return
+	}
+	payloads := make([]any, len(evs))
+	for i, ev := range evs {
+		we := wireEvent{Type: ev.typ, Username: ev.username, Room: ev.room, At: ev.at.UnixMilli(), Audience: ev.audience}
+		if ev.typ == eventStatusChanged {
+			we.Status = toWireStatus(ev.status)
+			we.Rooms = ev.rooms
+		}
+		b, _ := json.Marshal(we)
+		payloads[i] = string(b)
+	}
+	pipe.Eval(ctx, publishScript, []string{r.keys.seq(), r.keys.events()}, payloads...)
+}
+
+// relayEvents forwards published events to local watchers. If the sequence
+// skips, e.g. after the subscription reconnected, events were lost and every
+// watcher is dropped so it resubscribes from a fresh snapshot.
+func (r *redisStore) relayEvents() {
+	for msg := range r.pubsub.Channel() {
+		seqStr, payload, _ := strings.Cut(msg.Payload, " ")
+		seq, err := strconv.ParseUint(seqStr, 10, 64)
+		var we wireEvent
+		if err == nil {
+			err = json.Unmarshal([]byte(payload), &we)
+		}
+		if err != nil {
+			slog.Warn("invalid presence event", "payload", msg.Payload, "error", err)
+			continue
+		}
+		ev := event{seq: seq, typ: we.Type, username: we.Username, room: we.Room, at: time.UnixMilli(we.At), audience: we.Audience, rooms: we.Rooms}
+		if we.Status != nil {
+			ev.status = we.Status.userStatus(we.Username)
+		}
+
+		r.mu.Lock()
+		if r.lastSeq != 0 && seq != r.lastSeq+1 {
+			slog.Warn("presence events lost, dropping watchers", "expected", r.lastSeq+1, "got", seq)
+			r.hub.reset()
+		}
+		r.lastSeq = seq
+		r.hub.send(ev)
+		r.mu.Unlock()
+	}
+}
+
+// getStatus reads a user's status with cmd, e.g. a tx or the client.
+func (r *redisStore) getStatus(ctx context.Context, cmd redis.Cmdable, username string) (userStatus, error) {
+	sts, err := r.getStatuses(ctx, cmd, []string{username})
+	if err != nil {
+		return userStatus{}, err
+	}
+	return sts[0], nil
+}
+
+// getStatuses reads the status of each user, defaulting to plain online.
+func (r *redisStore) getStatuses(ctx context.Context, cmd redis.Cmdable, usernames []string) ([]userStatus, error) {
+	sts := make([]userStatus, len(usernames))
+	if len(usernames) == 0 {
+		return sts, nil
+	}
+	keys := make([]string, len(usernames))
+	for i, u := range usernames {
+		keys[i] = r.keys.status(u)
+	}
+	vals, err := cmd.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		sts[i] = userStatus{username: usernames[i]}
+		if s, ok := v.(string); ok {
+			var ws wireStatus
+			if json.Unmarshal([]byte(s), &ws) == nil {
+				sts[i] = ws.userStatus(usernames[i])
+			}
+		}
+	}
+	return sts, nil
+}
+
+// visible filters usernames down to those viewer may see, sorted.
+func (r *redisStore) visible(ctx context.Context, cmd redis.Cmdable, usernames []string, viewer string) ([]string, error) {
+	sts, err := r.getStatuses(ctx, cmd, usernames)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]string, 0, len(usernames))
+	for _, st := range sts {
+		if st.username == viewer || st.status != statusInvisible {
+			users = append(users, st.username)
+		}
+	}
+	slices.Sort(users)
+	return users, nil
+}
+
+// audience is who may see username's transitions.
+func (r *redisStore) audience(st userStatus) audience {
+	if st.status == statusInvisible {
+		return audienceSelf
+	}
+	return audienceAll
+}
+
+func (r *redisStore) connect(c connection, room string) ([]string, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if c.id == "" {
+		n, err := r.rdb.Incr(ctx, r.keys.anonSeq()).Result()
+		if err != nil {
+			return nil, err
+		}
+		c.id = fmt.Sprintf("anon-%d", n)
+		c.anonymous = true
+	}
+
+	var added bool
+	err := r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		owner, err := tx.HGet(ctx, r.keys.conn(c.id), "username").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		exists := err == nil
+		added = !exists
+		if exists && owner != c.username {
+			return errConnectionConflict
+		}
+		count, err := tx.SCard(ctx, r.keys.userConns(c.username)).Result()
+		if err != nil {
+			return err
+		}
+		inRoom := false
+		if room != "" {
+			if inRoom, err = tx.SIsMember(ctx, r.keys.userRooms(c.username), room).Result(); err != nil {
+				return err
+			}
+		}
+		st, err := r.getStatus(ctx, tx, c.username)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var evs []event
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			expiresAt := now.Add(r.leaseTTL)
+			if exists {
+				if !c.anonymous {
+					pipe.HSet(ctx, r.keys.conn(c.id), "expires_at", expiresAt.UnixMilli())
+					pipe.ZAdd(ctx, r.keys.leases(), redis.Z{Score: float64(expiresAt.UnixMilli()), Member: c.id})
+				}
+			} else {
+				fields := map[string]any{
+					"username":     c.username,
+					"node":         c.node,
+					"client_type":  c.clientType,
+					"connected_at": now.UnixMilli(),
+					"anonymous":    c.anonymous,
+				}
+				if !c.anonymous {
+					fields["expires_at"] = expiresAt.UnixMilli()
+					pipe.ZAdd(ctx, r.keys.leases(), redis.Z{Score: float64(expiresAt.UnixMilli()), Member: c.id})
+				}
+				pipe.HSet(ctx, r.keys.conn(c.id), fields)
+				pipe.SAdd(ctx, r.keys.userConns(c.username), c.id)
+				if c.node != "" {
+					pipe.SAdd(ctx, r.keys.nodeConns(c.node), c.id)
+				}
+				if count == 0 {
+					pipe.SAdd(ctx, r.keys.online(), c.username)
+					evs = append(evs, event{typ: eventOnline, username: c.username, at: now, audience: r.audience(st)})
+				}
+			}
+			if room != "" && !inRoom {
+				pipe.SAdd(ctx, r.keys.room(room), c.username)
+				pipe.SAdd(ctx, r.keys.userRooms(c.username), room)
+				evs = append(evs, event{typ: eventJoined, username: c.username, room: room, at: now, audience: r.audience(st)})
+			}
+			r.publish(ctx, pipe, evs)
+			return nil
+		})
+		return err
+	}, r.keys.conn(c.id), r.keys.userConns(c.username), r.keys.userRooms(c.username), r.keys.status(c.username))
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		connectsTotal.Inc()
+	}
+	return r.onlineUsers(c.username)
+}
+
+func (r *redisStore) disconnect(username, connID string) error {
+	if connID == "" {
+		id, err := r.newestAnonymous(username)
+		if err != nil || id == "" {
+			return err
+		}
+		connID = id
+	}
+	return r.removeConn(username, connID, false)
+}
+
+// newestAnonymous returns the ID of username's most recent anonymous connection.
+func (r *redisStore) newestAnonymous(username string) (string, error) {
+	conns, err := r.userConnections(username)
+	if err != nil {
+		return "", err
+	}
+	for i := len(conns) - 1; i >= 0; i-- {
+		if conns[i].anonymous {
+			return conns[i].id, nil
+		}
+	}
+	return "", nil
+}
+
+// removeConn drops a connection and, if it was the user's last one, takes the
+// user offline exactly like the in-memory store. With onlyExpired it only
+// removes the connection if its lease has run out, for the reaper.
+func (r *redisStore) removeConn(username, connID string, onlyExpired bool) error {
+	k := r.keys
+	var removed bool
+	err := r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		removed = false
+		vals, err := tx.HMGet(ctx, k.conn(connID), "username", "node").Result()
+		if err != nil {
+			return err
+		}
+		owner, _ := vals[0].(string)
+		node, _ := vals[1].(string)
+		if owner != username {
+			return nil
+		}
+		now := time.Now()
+		if onlyExpired {
+			expiresAt, err := tx.ZScore(ctx, k.leases(), connID).Result()
+			if err == redis.Nil || err == nil && int64(expiresAt) >= now.UnixMilli() {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		conns, err := tx.SMembers(ctx, k.userConns(username)).Result()
+		if err != nil {
+			return err
+		}
+		last := len(conns) == 1 && conns[0] == connID
+
+		var rooms []string
+		var typing []typingKey
+		var st userStatus
+		if last {
+			if rooms, err = tx.SMembers(ctx, k.userRooms(username)).Result(); err != nil {
+				return err
+			}
+			slices.Sort(rooms)
+			members, err := tx.ZRange(ctx, k.typing(), 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			for _, m := range members {
+				if tk := parseTypingMember(m); tk.username == username {
+					typing = append(typing, tk)
+				}
+			}
+			if st, err = r.getStatus(ctx, tx, username); err != nil {
+				return err
+			}
+		}
+
+		removed = true
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, k.conn(connID))
+			pipe.SRem(ctx, k.userConns(username), connID)
+			pipe.ZRem(ctx, k.leases(), connID)
+			if node != "" {
+				pipe.SRem(ctx, k.nodeConns(node), connID)
+			}
+			if !last {
+				return nil
+			}
+			var evs []event
+			pipe.SRem(ctx, k.online(), username)
+			for _, tk := range typing {
+				pipe.ZRem(ctx, k.typing(), typingMember(tk))
+				evs = append(evs, event{typ: eventTypingStopped, username: username, room: tk.room, at: now, audience: r.audience(st)})
+			}
+			for _, room := range rooms {
+				pipe.SRem(ctx, k.room(room), username)
+				evs = append(evs, event{typ: eventLeft, username: username, room: room, at: now, audience: r.audience(st)})
+			}
+			pipe.Del(ctx, k.userRooms(username))
+			// Invisible users were last seen when they went invisible.
+			if st.status != statusInvisible {
+				pipe.HSet(ctx, k.lastSeen(), username, now.UnixMilli())
+			}
+			evs = append(evs, event{typ: eventOffline, username: username, at: now, audience: r.audience(st)})
+			r.publish(ctx, pipe, evs)
+			return nil
+		})
+		return err
+	}, k.conn(connID), k.userConns(username), k.userRooms(username), k.typing(), k.status(username))
+	if err == nil && removed {
+		disconnectsTotal.Inc()
+	}
+	return err
+}
+
+func (r *redisStore) heartbeat(username, connID string) (time.Time, error) {
+	var expiresAt time.Time
+	err := r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		vals, err := tx.HMGet(ctx, r.keys.conn(connID), "username", "anonymous").Result()
+		if err != nil {
+			return err
+		}
+		if owner, _ := vals[0].(string); owner != username || vals[1] == "1" {
+			return errUnknownConnection
+		}
+		expiresAt = time.Now().Add(r.leaseTTL)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, r.keys.conn(connID), "expires_at", expiresAt.UnixMilli())
+			pipe.ZAdd(ctx, r.keys.leases(), redis.Z{Score: float64(expiresAt.UnixMilli()), Member: connID})
+			return nil
+		})
+		return err
+	}, r.keys.conn(connID))
+	return expiresAt, err
+}
+
+func (r *redisStore) joinRoom(username, room string) ([]string, error) {
+	err := r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		count, err := tx.SCard(ctx, r.keys.userConns(username)).Result()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errNotOnline
+		}
+		inRoom, err := tx.SIsMember(ctx, r.keys.userRooms(username), room).Result()
+		if err != nil || inRoom {
+			return err
+		}
+		st, err := r.getStatus(ctx, tx, username)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, r.keys.room(room), username)
+			pipe.SAdd(ctx, r.keys.userRooms(username), room)
+			r.publish(ctx, pipe, []event{{typ: eventJoined, username: username, room: room, at: time.Now(), audience: r.audience(st)}})
+			return nil
+		})
+		return err
+	}, r.keys.userConns(username), r.keys.userRooms(username), r.keys.status(username))
+	if err != nil {
+		return nil, err
+	}
+	return r.roomOnlineUsers(room, username)
+}
+
+func (r *redisStore) leaveRoom(username, room string) error {
+	member := typingMember(typingKey{room: room, username: username})
+	return r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		_, err := tx.ZScore(ctx, r.keys.typing(), member).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		wasTyping := err == nil
+		inRoom, err := tx.SIsMember(ctx, r.keys.userRooms(username), room).Result()
+		if err != nil {
+			return err
+		}
+		st, err := r.getStatus(ctx, tx, username)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			var evs []event
+			if wasTyping {
+				pipe.ZRem(ctx, r.keys.typing(), member)
+				evs = append(evs, event{typ: eventTypingStopped, username: username, room: room, at: now, audience: r.audience(st)})
+			}
+			if inRoom {
+				pipe.SRem(ctx, r.keys.room(room), username)
+				pipe.SRem(ctx, r.keys.userRooms(username), room)
+				evs = append(evs, event{typ: eventLeft, username: username, room: room, at: now, audience: r.audience(st)})
+			}
+			r.publish(ctx, pipe, evs)
+			return nil
+		})
+		return err
+	}, r.keys.typing(), r.keys.userRooms(username), r.keys.status(username))
+}
+
+func (r *redisStore) setTyping(username, room string, isTyping bool) error {
+	member := typingMember(typingKey{room: room, username: username})
+	return r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		_, err := tx.ZScore(ctx, r.keys.typing(), member).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		wasTyping := err == nil
+		if !isTyping && !wasTyping {
+			return nil
+		}
+		st, err := r.getStatus(ctx, tx, username)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if isTyping {
+				pipe.ZAdd(ctx, r.keys.typing(), redis.Z{Score: float64(now.UnixMilli()), Member: member})
+				if !wasTyping {
+					r.publish(ctx, pipe, []event{{typ: eventTypingStarted, username: username, room: room, at: now, audience: r.audience(st)}})
+				}
+			} else {
+				pipe.ZRem(ctx, r.keys.typing(), member)
+				r.publish(ctx, pipe, []event{{typ: eventTypingStopped, username: username, room: room, at: now, audience: r.audience(st)}})
+			}
+			return nil
+		})
+		return err
+	}, r.keys.typing(), r.keys.status(username))
+}
+
+func (r *redisStore) setStatus(st userStatus) (userStatus, error) {
+	err := r.updateStatus(st.username, func(old userStatus) (userStatus, bool) { return st, true })
+	if err != nil {
+		return userStatus{}, err
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	return r.getStatus(ctx, r.rdb, st.username)
+}
+
+// updateStatus replaces username's status with whatever change returns, unless
+// it declines, and publishes the resulting transitions.
+func (r *redisStore) updateStatus(username string, change func(old userStatus) (userStatus, bool)) error {
+	k := r.keys
+	return r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		old, err := r.getStatus(ctx, tx, username)
+		if err != nil {
+			return err
+		}
+		st, ok := change(old)
+		if !ok {
+			return nil
+		}
+		st.username = username
+		count, err := tx.SCard(ctx, k.userConns(username)).Result()
+		if err != nil {
+			return err
+		}
+		rooms, err := tx.SMembers(ctx, k.userRooms(username)).Result()
+		if err != nil {
+			return err
+		}
+		slices.Sort(rooms)
+		keys, err := r.typingKeys(ctx, tx)
+		if err != nil {
+			return err
+		}
+		var typing []string
+		for _, tk := range keys {
+			if tk.username == username {
+				typing = append(typing, tk.room)
+			}
+		}
+		slices.Sort(typing)
+		online := count > 0
+		now := time.Now()
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if st.isDefault() {
+				pipe.Del(ctx, k.status(username))
+			} else {
+				b, _ := json.Marshal(toWireStatus(st))
+				pipe.Set(ctx, k.status(username), b, 0)
+			}
+			if st.expiresAt.IsZero() {
+				pipe.ZRem(ctx, k.statusExpiry(), username)
+			} else {
+				pipe.ZAdd(ctx, k.statusExpiry(), redis.Z{Score: float64(st.expiresAt.UnixMilli()), Member: username})
+			}
+			if online && old.status != statusInvisible && st.status == statusInvisible {
+				pipe.HSet(ctx, k.lastSeen(), username, now.UnixMilli())
+			}
+			r.publish(ctx, pipe, statusChangeEvents(old, st, online, rooms, typing, now))
+			return nil
+		})
+		return err
+	}, k.status(username), k.userConns(username), k.userRooms(username), k.typing())
+}
+
+func (r *redisStore) onlineUsers(viewer string) ([]string, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	users, err := r.rdb.SMembers(ctx, r.keys.online()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.visible(ctx, r.rdb, users, viewer)
+}
+
+func (r *redisStore) roomOnlineUsers(room, viewer string) ([]string, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	users, err := r.rdb.SMembers(ctx, r.keys.room(room)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.visible(ctx, r.rdb, users, viewer)
+}
+
+// typingKeys returns every current typing status.
+func (r *redisStore) typingKeys(ctx context.Context, cmd redis.Cmdable) ([]typingKey, error) {
+	members, err := cmd.ZRange(ctx, r.keys.typing(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]typingKey, len(members))
+	for i, m := range members {
+		keys[i] = parseTypingMember(m)
+	}
+	return keys, nil
+}
+
+func (r *redisStore) typingUsers(viewer string) ([]string, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	keys, err := r.typingKeys(ctx, r.rdb)
+	if err != nil {
+		return nil, err
+	}
+	return r.visible(ctx, r.rdb, typingIn(keys, "", true), viewer)
+}
+
+func (r *redisStore) roomTypingUsers(room, viewer string) ([]string, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	keys, err := r.typingKeys(ctx, r.rdb)
+	if err != nil {
+		return nil, err
+	}
+	return r.visible(ctx, r.rdb, typingIn(keys, room, false), viewer)
+}
+
+// typingIn returns the deduplicated usernames typing in room, or anywhere if
+// all is set.
+func typingIn(keys []typingKey, room string, all bool) []string {
+	var users []string
+	for _, k := range keys {
+		if all || k.room == room {
+			users = append(users, k.username)
+		}
+	}
+	slices.Sort(users)
+	return slices.Compact(users)
+}
+
+func (r *redisStore) userStatuses(usernames []string) ([]userStatus, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	return r.getStatuses(ctx, r.rdb, usernames)
+}
+
+// userConnections returns a user's live connections, oldest first.
+func (r *redisStore) userConnections(username string) ([]connection, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	ids, err := r.rdb.SMembers(ctx, r.keys.userConns(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.loadConns(ctx, ids)
+}
+
+func (r *redisStore) connection(connID string) (connection, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	conns, err := r.loadConns(ctx, []string{connID})
+	if err != nil {
+		return connection{}, err
+	}
+	if len(conns) == 0 {
+		return connection{}, errUnknownConnection
+	}
+	return conns[0], nil
+}
+
+// loadConns reads the connections with the given IDs, oldest first, skipping
+// those that no longer exist.
+func (r *redisStore) loadConns(ctx context.Context, ids []string) ([]connection, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err := r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, r.keys.conn(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	conns := make([]connection, 0, len(ids))
+	for i, cmd := range cmds {
+		h := cmd.Val()
+		if h["username"] == "" {
+			continue // removed since the IDs were read
+		}
+		c := connection{
+			id:         ids[i],
+			username:   h["username"],
+			node:       h["node"],
+			clientType: h["client_type"],
+			anonymous:  h["anonymous"] == "1",
+		}
+		if ms, err := strconv.ParseInt(h["connected_at"], 10, 64); err == nil {
+			c.connectedAt = time.UnixMilli(ms)
+		}
+		if ms, err := strconv.ParseInt(h["expires_at"], 10, 64); err == nil {
+			c.expiresAt = time.UnixMilli(ms)
+		}
+		conns = append(conns, c)
+	}
+	sortConnections(conns)
+	return conns, nil
+}
+
+// lastSeenUsers returns last-seen times for the given users. Visibly online
+// users are seen now; invisible users report when they went invisible.
+func (r *redisStore) lastSeenUsers(usernames []string) ([]seen, error) {
+	presence, err := r.presence(usernames, "")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]seen, len(presence))
+	for i, p := range presence {
+		result[i] = seen{username: p.username, online: p.online, at: p.lastSeen}
+	}
+	return result, nil
+}
+
+// presence returns the presence of each given user as seen by viewer. An
+// invisible user looks entirely offline to anyone but themselves.
+func (r *redisStore) presence(usernames []string, viewer string) ([]userPresence, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if len(usernames) == 0 {
+		return []userPresence{}, nil
+	}
+
+	counts := make([]*redis.IntCmd, len(usernames))
+	var lastSeen *redis.SliceCmd
+	_, err := r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, u := range usernames {
+			counts[i] = pipe.SCard(ctx, r.keys.userConns(u))
+		}
+		lastSeen = pipe.HMGet(ctx, r.keys.lastSeen(), usernames...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sts, err := r.getStatuses(ctx, r.rdb, usernames)
+	if err != nil {
+		return nil, err
+	}
+	typing, err := r.typingKeys(ctx, r.rdb)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]userPresence, len(usernames))
+	for i, u := range usernames {
+		p := userPresence{username: u, status: userStatus{username: u}}
+		if s, ok := lastSeen.Val()[i].(string); ok {
+			if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+				p.lastSeen = time.UnixMilli(ms)
+			}
+		}
+		if u == viewer || sts[i].status != statusInvisible {
+			p.status = sts[i]
+			p.connections = int(counts[i].Val())
+			p.online = p.connections > 0
+			for _, k := range typing {
+				if k.username == u {
+					p.typingRooms = append(p.typingRooms, k.room)
+				}
+			}
+			slices.Sort(p.typingRooms)
+			if p.online {
+				p.lastSeen = now
+			}
+		}
+		result[i] = p
+	}
+	return result, nil
+}
+
+// watch takes a snapshot and the event sequence it reflects in one transaction,
+// then subscribes from that sequence. Events relayed in the meantime are
+// replayed from the hub's history.
+func (r *redisStore) watch(room, viewer string) (snap snapshot, events <-chan event, cancel func(), err error) {
+	var seq uint64
+	err = r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		if seq, err = tx.Get(ctx, r.keys.seq()).Uint64(); err != nil && err != redis.Nil {
+			return err
+		}
+		membersKey := r.keys.online()
+		if room != "" {
+			membersKey = r.keys.room(room)
+		}
+		members, err := tx.SMembers(ctx, membersKey).Result()
+		if err != nil {
+			return err
+		}
+		if snap.online, err = r.visible(ctx, tx, members, viewer); err != nil {
+			return err
+		}
+		if snap.statuses, err = r.getStatuses(ctx, tx, snap.online); err != nil {
+			return err
+		}
+		keys, err := r.typingKeys(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if snap.typing, err = r.visible(ctx, tx, typingIn(keys, room, room == ""), viewer); err != nil {
+			return err
+		}
+		// An empty transaction fails if any event was published since WATCH.
+		_, err = tx.TxPipelined(ctx, func(redis.Pipeliner) error { return nil })
+		return err
+	}, r.keys.seq())
+	if err != nil {
+		return snapshot{}, nil, nil, err
+	}
+
+	r.mu.Lock()
+	w, ok := r.hub.add(room, viewer, seq)
+	r.mu.Unlock()
+	if !ok {
+		return snapshot{}, nil, nil, errors.New("presence events moved on during snapshot, retry")
+	}
+	cancel = func() {
+		r.mu.Lock()
+		r.hub.remove(w)
+		r.mu.Unlock()
+	}
+	snap.seq = seq
+	return snap, w.ch, cancel, nil
+}
+
+// goAway tells this replica's watchers it is shutting down. It is not
+// published, since the other replicas keep serving.
+func (r *redisStore) goAway() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hub.goAway(event{seq: r.lastSeq, typ: eventGoingAway, at: time.Now()})
+}
+
+// resume registers a watcher for the events after seq after from the hub's
+// history. Sequence numbers are shared through Redis, so it works with one
+// seen on another replica, as long as this one has relayed it.
+func (r *redisStore) resume(room, viewer string, after uint64) (events <-chan event, cancel func(), ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.hub.covers(after, r.lastSeq) {
+		return nil, nil, false
+	}
+	w, _ := r.hub.add(room, viewer, after)
+	return w.ch, func() {
+		r.mu.Lock()
+		r.hub.remove(w)
+		r.mu.Unlock()
+	}, true
+}
+
+// cleanupExpiredTyping removes typing statuses older than the typing timeout.
+func (r *redisStore) cleanupExpiredTyping() error {
+	defer prometheus.NewTimer(typingSweepDuration).ObserveDuration()
+	r.mu.Lock()
+	timeout := r.typingTimeout
+	r.mu.Unlock()
+	var expired int
+	err := r.txn(func(ctx context.Context, tx *redis.Tx) error {
+		now := time.Now()
+		cutoff := now.Add(-timeout).UnixMilli()
+		members, err := tx.ZRangeByScore(ctx, r.keys.typing(), &redis.ZRangeBy{Min: "-inf", Max: "(" + strconv.FormatInt(cutoff, 10)}).Result()
+		if err != nil || len(members) == 0 {
+			return err
+		}
+		expired = len(members)
+		keys := make([]typingKey, len(members))
+		usernames := make([]string, len(members))
+		for i, m := range members {
+			keys[i] = parseTypingMember(m)
+			usernames[i] = keys[i].username
+		}
+		sts, err := r.getStatuses(ctx, tx, usernames)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			evs := make([]event, len(members))
+			for i, m := range members {
+				pipe.ZRem(ctx, r.keys.typing(), m)
+				evs[i] = event{typ: eventTypingExpired, username: keys[i].username, room: keys[i].room, at: now, audience: r.audience(sts[i])}
+			}
+			r.publish(ctx, pipe, evs)
+			return nil
+		})
+		return err
+	}, r.keys.typing())
+	if err == nil {
+		typingExpirationsTotal.Add(float64(expired))
+	}
+	return err
+}
+
+// dump reads the state key by key, so it is not a consistent snapshot of a
+// busy store. Statuses and rooms are found by scanning their keys.
+func (r *redisStore) dump() (stateDump, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	d := stateDump{typing: map[typingKey]time.Time{}, rooms: map[string][]string{}, nodes: map[string]time.Time{}}
+
+	users, err := r.rdb.SMembers(ctx, r.keys.online()).Result()
+	if err != nil {
+		return d, err
+	}
+	idCmds := make([]*redis.StringSliceCmd, len(users))
+	var typing, nodes *redis.ZSliceCmd
+	_, err = r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, u := range users {
+			idCmds[i] = pipe.SMembers(ctx, r.keys.userConns(u))
+		}
+		typing = pipe.ZRangeWithScores(ctx, r.keys.typing(), 0, -1)
+		nodes = pipe.ZRangeWithScores(ctx, r.keys.nodes(), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return d, err
+	}
+	var ids []string
+	for _, cmd := range idCmds {
+		ids = append(ids, cmd.Val()...)
+	}
+	if d.connections, err = r.loadConns(ctx, ids); err != nil {
+		return d, err
+	}
+	for _, z := range typing.Val() {
+		d.typing[parseTypingMember(z.Member.(string))] = time.UnixMilli(int64(z.Score))
+	}
+	for _, z := range nodes.Val() {
+		d.nodes[z.Member.(string)] = time.UnixMilli(int64(z.Score))
+	}
+
+	statusKeys, err := r.scan(ctx, r.keys.status("*"))
+	if err != nil {
+		return d, err
+	}
+	usernames := make([]string, len(statusKeys))
+	for i, k := range statusKeys {
+		usernames[i] = strings.TrimPrefix(k, r.keys.status(""))
+	}
+	slices.Sort(usernames)
+	sts, err := r.getStatuses(ctx, r.rdb, usernames)
+	if err != nil {
+		return d, err
+	}
+	for _, st := range sts {
+		if !st.isDefault() {
+			d.statuses = append(d.statuses, st)
+		}
+	}
+
+	roomKeys, err := r.scan(ctx, r.keys.room("*"))
+	if err != nil {
+		return d, err
+	}
+	for _, k := range roomKeys {
+		members, err := r.rdb.SMembers(ctx, k).Result()
+		if err != nil {
+			return d, err
+		}
+		if len(members) > 0 {
+			slices.Sort(members)
+			d.rooms[strings.TrimPrefix(k, r.keys.room(""))] = members
+		}
+	}
+	return d, nil
+}
+
+// scan returns every key matching pattern.
+func (r *redisStore) scan(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := r.rdb.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (r *redisStore) setTypingTimeout(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.typingTimeout = d
+}
+
+// stats counts each online user's connections, so a scrape costs a round trip
+// per online user, pipelined.
+func (r *redisStore) stats() (storeStats, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	users, err := r.rdb.SMembers(ctx, r.keys.online()).Result()
+	if err != nil {
+		return storeStats{}, err
+	}
+	var typing *redis.IntCmd
+	counts := make([]*redis.IntCmd, len(users))
+	_, err = r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		typing = pipe.ZCard(ctx, r.keys.typing())
+		for i, u := range users {
+			counts[i] = pipe.SCard(ctx, r.keys.userConns(u))
+		}
+		return nil
+	})
+	if err != nil {
+		return storeStats{}, err
+	}
+	st := storeStats{users: len(users), typing: int(typing.Val())}
+	for _, c := range counts {
+		st.connections += int(c.Val())
+	}
+	return st, nil
+}
+
+// reapExpiredLeases disconnects expired connections exactly as an explicit
+// disconnect would, emitting the same offline transitions.
+func (r *redisStore) reapExpiredLeases() error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	ids, err := r.rdb.ZRangeByScore(ctx, r.keys.leases(), &redis.ZRangeBy{Min: "-inf", Max: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10)}).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		username, err := r.rdb.HGet(ctx, r.keys.conn(id), "username").Result()
+		if err == redis.Nil {
+			r.rdb.ZRem(ctx, r.keys.leases(), id) // orphaned lease
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := r.removeConn(username, id, true); err != nil {
+			return err
+		}
+		slog.Info("connection lease expired", "username", username, "connection_id", id)
+	}
+	return nil
+}
+
+// expireStatuses resets statuses whose expiry has passed back to plain online.
+func (r *redisStore) expireStatuses() error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	users, err := r.rdb.ZRangeByScore(ctx, r.keys.statusExpiry(), &redis.ZRangeBy{Min: "-inf", Max: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10)}).Result()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		err := r.updateStatus(u, func(old userStatus) (userStatus, bool) {
+			return userStatus{}, !old.expiresAt.IsZero() && time.Now().After(old.expiresAt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerNode registers a chat node, or renews its lease.
+func (r *redisStore) registerNode(node string) (time.Time, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	expiresAt := time.Now().Add(r.leaseTTL)
+	err := r.rdb.ZAdd(ctx, r.keys.nodes(), redis.Z{Score: float64(expiresAt.UnixMilli()), Member: node}).Err()
+	return expiresAt, err
+}
+
+// purgeNode forgets a chat node and drops every connection tagged with it.
+func (r *redisStore) purgeNode(node string) (int, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if err := r.rdb.ZRem(ctx, r.keys.nodes(), node).Err(); err != nil {
+		return 0, err
+	}
+	ids, err := r.rdb.SMembers(ctx, r.keys.nodeConns(node)).Result()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		username, err := r.rdb.HGet(ctx, r.keys.conn(id), "username").Result()
+		if err == redis.Nil {
+			r.rdb.SRem(ctx, r.keys.nodeConns(node), id)
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		if err := r.removeConn(username, id, false); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// reapExpiredNodes purges chat nodes that stopped renewing their lease. A node
+// renewed through another replica in the meantime is left alone.
+func (r *redisStore) reapExpiredNodes() error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	nodes, err := r.rdb.ZRangeByScore(ctx, r.keys.nodes(), &redis.ZRangeBy{Min: "-inf", Max: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10)}).Result()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		expired := false
+		err := r.txn(func(ctx context.Context, tx *redis.Tx) error {
+			deadline, err := tx.ZScore(ctx, r.keys.nodes(), node).Result()
+			if err == redis.Nil || err == nil && int64(deadline) >= time.Now().UnixMilli() {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZRem(ctx, r.keys.nodes(), node)
+				return nil
+			})
+			expired = err == nil
+			return err
+		}, r.keys.nodes())
+		if err != nil {
+			return err
+		}
+		if !expired {
+			continue
+		}
+		n, err := r.purgeNode(node)
+		if err != nil {
+			return err
+		}
+		slog.Warn("chat node lease expired", "node", node, "connections", n)
+	}
+	return nil
+}
+
+// stopCleanup stops the sweeps and the event relay, dropping every watcher.
+func (r *redisStore) stopCleanup() {
+	close(r.cleanupDone)
+	r.pubsub.Close()
+	r.mu.Lock()
+	r.hub.reset()
+	r.mu.Unlock()
+}
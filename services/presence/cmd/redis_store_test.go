@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedisStore opens a redisStore on mr, as one replica of many.
+func newTestRedisStore(t *testing.T, mr *miniredis.Miniredis) *redisStore {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	r, err := newRedisStore(rdb, "presence:", defaultTunables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.stopCleanup()
+		rdb.Close()
+	})
+	return r
+}
+
+func TestRedisConnectDisconnect(t *testing.T) {
+	client := serveBackend(t, newTestRedisStore(t, miniredis.RunT(t)))
+	ctx := context.Background()
+
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1", Node: "chat-0", Room: "d1"})
+	client.UserConnected(ctx, &pb.UserRequest{Username: "alice"}) // anonymous
+	resp, err := client.UserConnected(ctx, &pb.UserRequest{Username: "bob", ConnectionId: "s2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Usernames) != 2 {
+		t.Fatalf("expected 2 online users, got %v", resp.Usernames)
+	}
+
+	conns, _ := client.GetUserConnections(ctx, &pb.UserRequest{Username: "alice"})
+	if len(conns.Connections) != 2 || conns.Connections[0].Id != "s1" || conns.Connections[0].Node != "chat-0" {
+		t.Fatalf("expected connections s1 and an anonymous one, got %v", conns.Connections)
+	}
+	room, _ := client.GetRoomOnlineUsers(ctx, &pb.RoomRequest{Room: "d1"})
+	if len(room.Usernames) != 1 || room.Usernames[0] != "alice" {
+		t.Fatalf("expected [alice] in d1, got %v", room.Usernames)
+	}
+
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice", ConnectionId: "s1"})
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice"})
+	client.UserDisconnected(ctx, &pb.UserRequest{Username: "alice"}) // no-op
+	online, _ := client.GetOnlineUsers(ctx, &pb.OnlineUsersRequest{})
+	if len(online.Usernames) != 1 || online.Usernames[0] != "bob" {
+		t.Fatalf("expected [bob], got %v", online.Usernames)
+	}
+	room, _ = client.GetRoomOnlineUsers(ctx, &pb.RoomRequest{Room: "d1"})
+	if len(room.Usernames) != 0 {
+		t.Fatalf("expected d1 empty, got %v", room.Usernames)
+	}
+
+	seen, _ := client.GetLastSeen(ctx, &pb.UsersRequest{Usernames: []string{"alice"}})
+	if seen.Users[0].Online || seen.Users[0].LastSeen == 0 {
+		t.Fatalf("expected alice offline with a last-seen time, got %v", seen.Users[0])
+	}
+}
+
+func TestRedisStatusAndTyping(t *testing.T) {
+	r := newTestRedisStore(t, miniredis.RunT(t))
+	r.connect(connection{id: "s1", username: "alice"}, "d1")
+	r.connect(connection{id: "s2", username: "bob"}, "d1")
+
+	r.setStatus(userStatus{username: "bob", status: statusInvisible})
+	if users, _ := r.roomOnlineUsers("d1", "alice"); len(users) != 1 || users[0] != "alice" {
+		t.Fatalf("expected bob hidden from alice, got %v", users)
+	}
+	if users, _ := r.roomOnlineUsers("d1", "bob"); len(users) != 2 {
+		t.Fatalf("expected bob to see himself, got %v", users)
+	}
+
+	r.setTyping("alice", "d1", true)
+	r.setTyping("bob", "d1", true)
+	if users, _ := r.roomTypingUsers("d1", ""); len(users) != 1 || users[0] != "alice" {
+		t.Fatalf("expected [alice] typing in d1, got %v", users)
+	}
+	if users, _ := r.roomTypingUsers("d1", "bob"); len(users) != 2 {
+		t.Fatalf("expected bob to see himself typing, got %v", users)
+	}
+	r.setTyping("bob", "d1", false)
+	p, _ := r.presence([]string{"alice", "bob"}, "")
+	if !p[0].online || len(p[0].typingRooms) != 1 || p[1].online {
+		t.Fatalf("expected alice online and typing, bob hidden, got %+v", p)
+	}
+
+	// Backdate alice's typing so the sweep expires it
+	ctx := context.Background()
+	r.rdb.ZAdd(ctx, r.keys.typing(), redis.Z{Score: float64(time.Now().Add(-9 * time.Second).UnixMilli()), Member: typingMember(typingKey{room: "d1", username: "alice"})})
+	if err := r.cleanupExpiredTyping(); err != nil {
+		t.Fatal(err)
+	}
+	if users, _ := r.typingUsers(""); len(users) != 0 {
+		t.Fatalf("expected typing expired, got %v", users)
+	}
+}
+
+func TestRedisLeaseExpiry(t *testing.T) {
+	r := newTestRedisStore(t, miniredis.RunT(t))
+	r.leaseTTL = 50 * time.Millisecond
+
+	r.connect(connection{id: "s1", username: "alice"}, "")
+	time.Sleep(30 * time.Millisecond)
+	if _, err := r.heartbeat("alice", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	r.reapExpiredLeases()
+	if users, _ := r.onlineUsers(""); len(users) != 1 {
+		t.Fatalf("expected alice still online, got %v", users)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	r.reapExpiredLeases()
+	if users, _ := r.onlineUsers(""); len(users) != 0 {
+		t.Fatalf("expected alice reaped, got %v", users)
+	}
+}
+
+func TestRedisReplicasShareState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestRedisStore(t, mr)
+	b := newTestRedisStore(t, mr)
+
+	a.connect(connection{id: "s1", username: "alice"}, "")
+	snap, events, cancel, err := b.watch("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if len(snap.online) != 1 || snap.online[0] != "alice" {
+		t.Fatalf("expected alice in replica b's snapshot, got %v", snap.online)
+	}
+
+	// Changes made through one replica reach watchers of the other
+	a.connect(connection{id: "s2", username: "bob"}, "")
+	a.setTyping("bob", "", true)
+	a.disconnect("bob", "s2")
+
+	want := []eventType{eventOnline, eventTypingStarted, eventTypingStopped, eventOffline}
+	var seqs []uint64
+	for _, typ := range want {
+		select {
+		case ev := <-events:
+			if ev.typ != typ || ev.username != "bob" {
+				t.Fatalf("expected %v for bob, got %+v", typ, ev)
+			}
+			seqs = append(seqs, ev.seq)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v event for bob", typ)
+		}
+	}
+
+	// Sequence numbers are shared, so a watcher of b can resume on a.
+	var resumed <-chan event
+	for deadline := time.Now().Add(time.Second); resumed == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("replica a cannot resume after an event seen on b")
+		}
+		if ch, cancel, ok := a.resume("", "", seqs[1]); ok {
+			defer cancel()
+			resumed = ch
+		}
+	}
+	if ev := <-resumed; ev.typ != eventTypingStopped || ev.seq != seqs[2] {
+		t.Fatalf("expected bob's typing stopped first, got %+v", ev)
+	}
+
+	if _, err := b.heartbeat("alice", "s1"); err != nil {
+		t.Fatalf("expected replica b to renew alice's lease, got %v", err)
+	}
+}
+
+func TestRedisNodeExpiry(t *testing.T) {
+	r := newTestRedisStore(t, miniredis.RunT(t))
+	r.leaseTTL = 50 * time.Millisecond
+
+	r.registerNode("chat-0")
+	r.connect(connection{username: "alice", node: "chat-0"}, "")
+	r.connect(connection{username: "bob", node: "chat-1"}, "")
+
+	time.Sleep(60 * time.Millisecond)
+	if err := r.reapExpiredNodes(); err != nil {
+		t.Fatal(err)
+	}
+	if users, _ := r.onlineUsers(""); len(users) != 1 || users[0] != "bob" {
+		t.Fatalf("expected [bob], got %v", users)
+	}
+	if n, _ := r.purgeNode("chat-1"); n != 1 {
+		t.Fatalf("expected 1 connection purged, got %d", n)
+	}
+}
+
+func TestRedisStats(t *testing.T) {
+	r := newTestRedisStore(t, miniredis.RunT(t))
+	r.connect(connection{id: "s1", username: "alice"}, "")
+	r.connect(connection{id: "s2", username: "alice"}, "")
+	r.connect(connection{username: "bob"}, "")
+	r.setTyping("bob", "d1", true)
+
+	st, err := r.stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != (storeStats{users: 2, connections: 3, typing: 1}) {
+		t.Fatalf("expected 2 users, 3 connections and 1 typing, got %+v", st)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// reloader applies a new config to the running server, on SIGHUP or when the
+// config file changes. Only the log level, typing timeout, rate limits and
+// authorization lists take effect; other changes are logged and wait for a
+// restart. A config that fails to load or validate changes nothing.
+type reloader struct {
+	args   []string
+	getenv func(string) string
+	store  backend
+	limits *rateLimits
+	authz  *authorizer
+	file   string // the config file, if any
+
+	mu      sync.Mutex
+	cfg     *config   // the settings in effect
+	modTime time.Time // of the config file when it was last read
+}
+
+func newReloader(cfg *config, args []string, getenv func(string) string, store backend, limits *rateLimits, authz *authorizer) *reloader {
+	r := &reloader{args: args, getenv: getenv, store: store, limits: limits, authz: authz, file: cfg.file, cfg: cfg}
+	if fi, err := os.Stat(r.file); err == nil {
+		r.modTime = fi.ModTime()
+	}
+	return r
+}
+
+// reload loads the config again and applies what can change in place.
+// trigger says what asked for it, for the log.
+func (r *reloader) reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := loadConfig(r.args, r.getenv)
+	var applied config
+	var policy *authPolicy
+	if err == nil {
+		applied = r.cfg.withReloadable(next)
+		err = applied.validate()
+	}
+	if err == nil {
+		policy, err = newAuthPolicy(applied.Auth)
+	}
+	if err != nil {
+		slog.Error("config reload rejected, keeping the current config", "trigger", trigger, "error", err)
+		return err
+	}
+
+	logLevel.UnmarshalText([]byte(applied.Log.Level)) // validate rejected unknown levels
+	if applied.Store.TypingTimeout != r.cfg.Store.TypingTimeout {
+		r.store.setTypingTimeout(applied.Store.TypingTimeout.Duration)
+	}
+	// Unchanged limiters are kept, so that reloading does not refill every bucket.
+	if l := applied.RateLimit.User; l != r.cfg.RateLimit.User {
+		r.limits.user.Store(newRateLimiter(l.Rate, l.Burst))
+	}
+	if l := applied.RateLimit.Caller; l != r.cfg.RateLimit.Caller {
+		r.limits.caller.Store(newRateLimiter(l.Rate, l.Burst))
+	}
+	r.authz.policy.Store(policy)
+
+	changed, ignored := diffConfig(r.cfg, &applied), diffConfig(&applied, next)
+	r.cfg = &applied
+	slog.Info("config reloaded", "trigger", trigger, "changed", changed)
+	if len(ignored) > 0 {
+		slog.Warn("config changes need a restart, ignored", "settings", ignored)
+	}
+	return nil
+}
+
+// withReloadable returns c with the settings of next that reload applies.
+func (c *config) withReloadable(next *config) config {
+	applied := *c
+	applied.Log = next.Log
+	applied.Store.TypingTimeout = next.Store.TypingTimeout
+	applied.RateLimit = next.RateLimit
+	applied.Auth = next.Auth
+	applied.Auth.AuditLog = c.Auth.AuditLog // opened once at startup
+	return applied
+}
+
+// checkFile reloads the config if its file was modified since it was last read.
+func (r *reloader) checkFile() {
+	fi, err := os.Stat(r.file)
+	if err != nil {
+		slog.Error("failed to check config file", "error", err)
+		return
+	}
+	r.mu.Lock()
+	modified := !fi.ModTime().Equal(r.modTime)
+	r.modTime = fi.ModTime()
+	r.mu.Unlock()
+	if modified {
+		r.reload("file")
+	}
+}
+
+// watch checks the config file every interval until done is closed. It does
+// nothing when the config has no file or interval is 0.
+func (r *reloader) watch(interval time.Duration, done <-chan struct{}) {
+	if r.file == "" || interval <= 0 {
+		return
+	}
+	runSweep(interval, done, r.checkFile)
+}
+
+// diffConfig returns the file keys of the settings that differ between a and b.
+func diffConfig(a, b *config) []string {
+	fa, fb := flattenConfig(a), flattenConfig(b)
+	var keys []string
+	for k, v := range fa {
+		if fb[k] != v {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// flattenConfig maps the dotted file key of every setting of c to its value.
+func flattenConfig(c *config) map[string]string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	var tree map[string]any
+	json.Unmarshal(data, &tree)
+	flat := map[string]string{}
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		m, ok := v.(map[string]any)
+		if !ok {
+			flat[prefix] = fmt.Sprint(v)
+			return
+		}
+		for k, e := range m {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			walk(k, e)
+		}
+	}
+	walk("", tree)
+	return flat
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "presence.yaml")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, path, []byte(`
+store: {typing_timeout: 8s}
+rate_limit:
+  user: {rate: 10, burst: 20}
+  caller: {rate: 100, burst: 100}
+auth: {writers: [chat]}
+`), start)
+	getenv := func(k string) string { return map[string]string{"CONFIG_FILE": path}[k] }
+	cfg, err := loadConfig(nil, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newStore(cfg.Store.tunables())
+	defer s.stopCleanup()
+	limits := newRateLimitsFromConfig(cfg.RateLimit)
+	authz, err := newAuthorizerFromConfig(cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+	r := newReloader(cfg, nil, getenv, s, limits, authz)
+	caller := limits.caller.Load()
+
+	writeFile(t, path, []byte(`
+listen: {grpc: ":7000"}
+log: {level: debug}
+store: {typing_timeout: 3s}
+rate_limit:
+  user: {rate: 0}
+  caller: {rate: 100, burst: 100}
+auth: {writers: [chat, bots]}
+`), start.Add(time.Second))
+	r.checkFile()
+
+	if s.typingTimeout != 3*time.Second {
+		t.Errorf("typing timeout = %s, want 3s", s.typingTimeout)
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level = %s, want debug", logLevel.Level())
+	}
+	if limits.user.Load() != nil {
+		t.Error("user rate limit still on")
+	}
+	if limits.caller.Load() != caller {
+		t.Error("unchanged caller limiter replaced")
+	}
+	if got := authz.policy.Load().roles["bots"]; got != roleWriter {
+		t.Errorf("bots role = %s, want writer", got)
+	}
+	if r.cfg.Listen.GRPC != ":50051" {
+		t.Errorf("listen.grpc = %q, applied without a restart", r.cfg.Listen.GRPC)
+	}
+
+	// An invalid config is rejected as a whole.
+	writeFile(t, path, []byte(`
+store: {typing_timeout: 1s}
+auth: {anonymous_role: root}
+`), start.Add(2*time.Second))
+	if err := r.reload("test"); err == nil {
+		t.Fatal("invalid config applied")
+	}
+	if s.typingTimeout != 3*time.Second {
+		t.Errorf("typing timeout = %s after a rejected reload, want 3s", s.typingTimeout)
+	}
+	if got := authz.policy.Load().roles["bots"]; got != roleWriter {
+		t.Errorf("bots role = %s after a rejected reload, want writer", got)
+	}
+}
@@ -2,47 +2,366 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"time"
 
 	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // server implements the PresenceService gRPC interface.
 type server struct {
 	pb.UnimplementedPresenceServiceServer
-	store *store
+	store backend
+}
+
+// grpcError maps backend errors to gRPC status errors.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errNotOnline), errors.Is(err, errRemoteConnection):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errConnectionConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, errUnknownConnection):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Unavailable, err.Error())
+	}
 }
 
 func (s *server) UserConnected(ctx context.Context, req *pb.UserRequest) (*pb.OnlineUsersResponse, error) {
-	users := s.store.connect(req.Username)
-	slog.InfoContext(ctx, "user connected", "username", req.Username, "online_count", len(users))
-	return &pb.OnlineUsersResponse{Usernames: users}, nil
+	users, err := s.store.connect(connection{
+		id:         req.ConnectionId,
+		username:   req.Username,
+		node:       req.Node,
+		clientType: req.ClientType,
+	}, req.Room)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.InfoContext(ctx, "user connected", "username", req.Username, "connection_id", req.ConnectionId,
+		"node", req.Node, "room", req.Room, "online_count", len(users))
+	return s.onlineResponse(users)
 }
 
 func (s *server) UserDisconnected(ctx context.Context, req *pb.UserRequest) (*pb.Empty, error) {
-	s.store.disconnect(req.Username)
-	slog.InfoContext(ctx, "user disconnected", "username", req.Username)
+	if err := s.store.disconnect(req.Username, req.ConnectionId); err != nil {
+		return nil, grpcError(err)
+	}
+	slog.InfoContext(ctx, "user disconnected", "username", req.Username, "connection_id", req.ConnectionId)
 	return &pb.Empty{}, nil
 }
 
+func (s *server) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	expiresAt, err := s.store.heartbeat(req.Username, req.ConnectionId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.DebugContext(ctx, "heartbeat", "username", req.Username, "connection_id", req.ConnectionId)
+	return &pb.HeartbeatResponse{ExpiresAt: expiresAt.UnixMilli()}, nil
+}
+
+func (s *server) RegisterNode(ctx context.Context, req *pb.NodeRequest) (*pb.NodeResponse, error) {
+	if req.Node == "" {
+		return nil, status.Error(codes.InvalidArgument, "node is required")
+	}
+	expiresAt, err := s.store.registerNode(req.Node)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.DebugContext(ctx, "node heartbeat", "node", req.Node)
+	return &pb.NodeResponse{ExpiresAt: expiresAt.UnixMilli()}, nil
+}
+
+func (s *server) PurgeNode(ctx context.Context, req *pb.NodeRequest) (*pb.PurgeNodeResponse, error) {
+	if req.Node == "" {
+		return nil, status.Error(codes.InvalidArgument, "node is required")
+	}
+	n, err := s.store.purgeNode(req.Node)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.InfoContext(ctx, "node purged", "node", req.Node, "connections", n)
+	return &pb.PurgeNodeResponse{Connections: int32(n)}, nil
+}
+
 func (s *server) SetTyping(ctx context.Context, req *pb.SetTypingRequest) (*pb.Empty, error) {
-	s.store.setTyping(req.Username, req.IsTyping)
+	if err := s.store.setTyping(req.Username, req.Room, req.IsTyping); err != nil {
+		return nil, grpcError(err)
+	}
 	action := "started"
 	if !req.IsTyping {
 		action = "stopped"
 	}
-	slog.InfoContext(ctx, "user typing", "username", req.Username, "action", action)
+	slog.InfoContext(ctx, "user typing", "username", req.Username, "room", req.Room, "action", action)
 	return &pb.Empty{}, nil
 }
 
-func (s *server) GetOnlineUsers(ctx context.Context, _ *pb.Empty) (*pb.OnlineUsersResponse, error) {
-	users := s.store.onlineUsers()
+func (s *server) SetStatus(ctx context.Context, req *pb.SetStatusRequest) (*pb.UserStatus, error) {
+	if _, ok := pb.Status_name[int32(req.Status)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown status %d", req.Status)
+	}
+	st := userStatus{
+		username: req.Username,
+		status:   presenceStatus(req.Status),
+		text:     req.CustomText,
+		emoji:    req.Emoji,
+	}
+	if req.ExpiresAt > 0 {
+		st.expiresAt = time.UnixMilli(req.ExpiresAt)
+	}
+	st, err := s.store.setStatus(st)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.InfoContext(ctx, "user status", "username", req.Username, "status", req.Status.String())
+	return statusToProto(st), nil
+}
+
+func (s *server) GetOnlineUsers(ctx context.Context, req *pb.OnlineUsersRequest) (*pb.OnlineUsersResponse, error) {
+	users, err := s.store.onlineUsers(req.Viewer)
+	if err != nil {
+		return nil, grpcError(err)
+	}
 	slog.DebugContext(ctx, "get online users", "count", len(users))
-	return &pb.OnlineUsersResponse{Usernames: users}, nil
+	return s.onlineResponse(users)
 }
 
+// GetTypingUsers has no viewer, so invisible users are always left out.
 func (s *server) GetTypingUsers(ctx context.Context, _ *pb.Empty) (*pb.TypingUsersResponse, error) {
-	users := s.store.typingUsers()
+	users, err := s.store.typingUsers("")
+	if err != nil {
+		return nil, grpcError(err)
+	}
 	slog.DebugContext(ctx, "get typing users", "count", len(users))
 	return &pb.TypingUsersResponse{Usernames: users}, nil
 }
+
+func (s *server) GetUserConnections(ctx context.Context, req *pb.UserRequest) (*pb.ConnectionsResponse, error) {
+	conns, err := s.store.userConnections(req.Username)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ConnectionsResponse{Connections: make([]*pb.Connection, len(conns))}
+	for i, c := range conns {
+		resp.Connections[i] = connectionToProto(c)
+	}
+	slog.DebugContext(ctx, "get user connections", "username", req.Username, "count", len(conns))
+	return resp, nil
+}
+
+func (s *server) GetLastSeen(ctx context.Context, req *pb.UsersRequest) (*pb.LastSeenResponse, error) {
+	seen, err := s.store.lastSeenUsers(req.Usernames)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.LastSeenResponse{Users: make([]*pb.LastSeen, len(seen))}
+	for i, ls := range seen {
+		resp.Users[i] = &pb.LastSeen{Username: ls.username, Online: ls.online}
+		if !ls.at.IsZero() {
+			resp.Users[i].LastSeen = ls.at.UnixMilli()
+		}
+	}
+	slog.DebugContext(ctx, "get last seen", "count", len(seen))
+	return resp, nil
+}
+
+func (s *server) GetPresence(ctx context.Context, req *pb.UsersRequest) (*pb.PresenceResponse, error) {
+	presence, err := s.store.presence(req.Usernames, req.Viewer)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.PresenceResponse{Users: make([]*pb.UserPresence, len(presence))}
+	for i, p := range presence {
+		up := &pb.UserPresence{
+			Username:        p.username,
+			Online:          p.online,
+			Status:          statusToProto(p.status),
+			ConnectionCount: int32(p.connections),
+			Typing:          len(p.typingRooms) > 0,
+			TypingRooms:     p.typingRooms,
+		}
+		if !p.lastSeen.IsZero() {
+			up.LastSeen = p.lastSeen.UnixMilli()
+		}
+		resp.Users[i] = up
+	}
+	slog.DebugContext(ctx, "get presence", "count", len(presence))
+	return resp, nil
+}
+
+func (s *server) JoinRoom(ctx context.Context, req *pb.UserRequest) (*pb.OnlineUsersResponse, error) {
+	if req.Room == "" {
+		return nil, status.Error(codes.InvalidArgument, "room is required")
+	}
+	users, err := s.store.joinRoom(req.Username, req.Room)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.InfoContext(ctx, "user joined room", "username", req.Username, "room", req.Room, "online_count", len(users))
+	return s.onlineResponse(users)
+}
+
+func (s *server) LeaveRoom(ctx context.Context, req *pb.UserRequest) (*pb.Empty, error) {
+	if req.Room == "" {
+		return nil, status.Error(codes.InvalidArgument, "room is required")
+	}
+	if err := s.store.leaveRoom(req.Username, req.Room); err != nil {
+		return nil, grpcError(err)
+	}
+	slog.InfoContext(ctx, "user left room", "username", req.Username, "room", req.Room)
+	return &pb.Empty{}, nil
+}
+
+func (s *server) GetRoomOnlineUsers(ctx context.Context, req *pb.RoomRequest) (*pb.OnlineUsersResponse, error) {
+	users, err := s.store.roomOnlineUsers(req.Room, req.Viewer)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.DebugContext(ctx, "get room online users", "room", req.Room, "count", len(users))
+	return s.onlineResponse(users)
+}
+
+func (s *server) GetRoomTypingUsers(ctx context.Context, req *pb.RoomRequest) (*pb.TypingUsersResponse, error) {
+	users, err := s.store.roomTypingUsers(req.Room, req.Viewer)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	slog.DebugContext(ctx, "get room typing users", "room", req.Room, "count", len(users))
+	return &pb.TypingUsersResponse{Usernames: users}, nil
+}
+
+func (s *server) WatchPresence(req *pb.WatchPresenceRequest, stream grpc.ServerStreamingServer[pb.PresenceEvent]) error {
+	ctx := stream.Context()
+	if req.ResumeAfter != nil {
+		if events, cancel, ok := s.store.resume(req.Room, req.Viewer, *req.ResumeAfter); ok {
+			defer cancel()
+			slog.InfoContext(ctx, "watcher resumed", "room", req.Room, "viewer", req.Viewer, "after", *req.ResumeAfter)
+			defer slog.InfoContext(ctx, "watcher unsubscribed")
+			// There may be nothing to send for a while; answer now, so that
+			// proxies do not take the stream for a stalled one.
+			if err := stream.SendHeader(nil); err != nil {
+				return err
+			}
+			return sendEvents(ctx, events, stream)
+		}
+	}
+	snap, events, cancel, err := s.store.watch(req.Room, req.Viewer)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer cancel()
+
+	slog.InfoContext(ctx, "watcher subscribed", "room", req.Room, "viewer", req.Viewer, "online_count", len(snap.online))
+	defer slog.InfoContext(ctx, "watcher unsubscribed")
+
+	statuses := make([]*pb.UserStatus, len(snap.statuses))
+	for i, st := range snap.statuses {
+		statuses[i] = statusToProto(st)
+	}
+	err = stream.Send(&pb.PresenceEvent{
+		Type:      pb.PresenceEvent_SNAPSHOT,
+		Room:      req.Room,
+		Online:    snap.online,
+		Typing:    snap.typing,
+		Statuses:  statuses,
+		Timestamp: time.Now().UnixMilli(),
+		Seq:       snap.seq,
+	})
+	if err != nil {
+		return err
+	}
+	return sendEvents(ctx, events, stream)
+}
+
+// sendEvents streams events until ctx is done or the watcher is dropped.
+func sendEvents(ctx context.Context, events <-chan event, stream grpc.ServerStreamingServer[pb.PresenceEvent]) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind, resubscribe for a fresh snapshot")
+			}
+			if err := stream.Send(eventToProto(ev)); err != nil {
+				return err
+			}
+			if ev.typ == eventGoingAway {
+				return status.Error(codes.Unavailable, "server is shutting down, resubscribe")
+			}
+		}
+	}
+}
+
+var eventTypes = map[eventType]pb.PresenceEvent_Type{
+	eventOnline:        pb.PresenceEvent_ONLINE,
+	eventOffline:       pb.PresenceEvent_OFFLINE,
+	eventTypingStarted: pb.PresenceEvent_TYPING_STARTED,
+	eventTypingStopped: pb.PresenceEvent_TYPING_STOPPED,
+	eventTypingExpired: pb.PresenceEvent_TYPING_EXPIRED,
+	eventJoined:        pb.PresenceEvent_ROOM_JOINED,
+	eventLeft:          pb.PresenceEvent_ROOM_LEFT,
+	eventStatusChanged: pb.PresenceEvent_STATUS_CHANGED,
+	eventGoingAway:     pb.PresenceEvent_GOING_AWAY,
+}
+
+func eventToProto(ev event) *pb.PresenceEvent {
+	pev := &pb.PresenceEvent{
+		Type:      eventTypes[ev.typ],
+		Username:  ev.username,
+		Room:      ev.room,
+		Timestamp: ev.at.UnixMilli(),
+		Seq:       ev.seq,
+	}
+	if ev.typ == eventStatusChanged {
+		pev.Status = statusToProto(ev.status)
+	}
+	return pev
+}
+
+// onlineResponse pairs online usernames with their statuses.
+func (s *server) onlineResponse(users []string) (*pb.OnlineUsersResponse, error) {
+	statuses, err := s.store.userStatuses(users)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.OnlineUsersResponse{Usernames: users, Users: make([]*pb.UserStatus, len(users))}
+	for i, st := range statuses {
+		resp.Users[i] = statusToProto(st)
+	}
+	return resp, nil
+}
+
+func connectionToProto(c connection) *pb.Connection {
+	pc := &pb.Connection{
+		Id:          c.id,
+		Username:    c.username,
+		Node:        c.node,
+		ClientType:  c.clientType,
+		ConnectedAt: c.connectedAt.UnixMilli(),
+		Provisional: c.provisional,
+		Replica:     c.origin,
+		Anonymous:   c.anonymous,
+	}
+	if !c.expiresAt.IsZero() {
+		pc.ExpiresAt = c.expiresAt.UnixMilli()
+	}
+	return pc
+}
+
+func statusToProto(st userStatus) *pb.UserStatus {
+	ps := &pb.UserStatus{
+		Username:   st.username,
+		Status:     pb.Status(st.status),
+		CustomText: st.text,
+		Emoji:      st.emoji,
+	}
+	if !st.expiresAt.IsZero() {
+		ps.ExpiresAt = st.expiresAt.UnixMilli()
+	}
+	return ps
+}
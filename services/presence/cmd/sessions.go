@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sessionHeader is the metadata key write RPCs carry the end user's session token in.
+const sessionHeader = "x-session-token"
+
+// sessionMethods are the RPCs that act for the username in their request.
+var sessionMethods = map[string]bool{
+	pb.PresenceService_UserConnected_FullMethodName:    true,
+	pb.PresenceService_UserDisconnected_FullMethodName: true,
+	pb.PresenceService_Heartbeat_FullMethodName:        true,
+	pb.PresenceService_SetTyping_FullMethodName:        true,
+	pb.PresenceService_SetStatus_FullMethodName:        true,
+	pb.PresenceService_JoinRoom_FullMethodName:         true,
+	pb.PresenceService_LeaveRoom_FullMethodName:        true,
+}
+
+type cachedSession struct {
+	username string
+	expires  time.Time
+}
+
+// sessionBinding is the connections registered under one session, with when
+// each was last bound.
+type sessionBinding struct {
+	username string
+	conns    map[string]time.Time
+}
+
+// sessionValidator checks that write RPCs act for the user whose session token
+// they carry. Sessions are the session:<token> → username keys the accounts
+// service writes to Redis; lookups are cached for cacheTTL, so a revoked
+// session keeps working for at most that long, unless watch hears about it.
+//
+// It also remembers the connections registered under each session, so that
+// watch can drop them when the session is deleted or expires. Connections that
+// end some other way, e.g. by lease expiry, are forgotten by prune.
+type sessionValidator struct {
+	rdb      *redis.Client
+	prefix   string
+	cacheTTL time.Duration
+	audit    *slog.Logger
+
+	mu       sync.Mutex
+	cache    map[string]cachedSession   // by token
+	bindings map[string]*sessionBinding // by token
+}
+
+func newSessionValidator(rdb *redis.Client, prefix string, cacheTTL time.Duration, audit *slog.Logger) *sessionValidator {
+	return &sessionValidator{
+		rdb:      rdb,
+		prefix:   prefix,
+		cacheTTL: cacheTTL,
+		audit:    audit,
+		cache:    map[string]cachedSession{},
+		bindings: map[string]*sessionBinding{},
+	}
+}
+
+// username resolves token to the session's username, or "" if there is no such session.
+func (v *sessionValidator) username(ctx context.Context, token string) (string, error) {
+	now := time.Now()
+	v.mu.Lock()
+	c, ok := v.cache[token]
+	v.mu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.username, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	username, err := v.rdb.Get(ctx, v.prefix+token).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	v.mu.Lock()
+	v.cache[token] = cachedSession{username: username, expires: now.Add(v.cacheTTL)}
+	v.mu.Unlock()
+	return username, nil
+}
+
+// check returns the session token of a call acting for username, or the
+// status error to fail it with.
+func (v *sessionValidator) check(ctx context.Context, method, username string) (string, error) {
+	tokens := metadata.ValueFromIncomingContext(ctx, sessionHeader)
+	if len(tokens) == 0 || tokens[0] == "" {
+		v.audit.WarnContext(ctx, "rpc denied", "method", method, "username", username, "reason", "no session token")
+		return "", status.Error(codes.Unauthenticated, "session token required")
+	}
+	owner, err := v.username(ctx, tokens[0])
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to look up session", "error", err)
+		return "", status.Error(codes.Unavailable, "session store unavailable")
+	}
+	if owner == "" {
+		v.audit.WarnContext(ctx, "rpc denied", "method", method, "username", username, "reason", "unknown session")
+		return "", status.Error(codes.Unauthenticated, "unknown or expired session")
+	}
+	if owner != username {
+		v.audit.WarnContext(ctx, "rpc denied", "method", method, "username", username, "session_user", owner, "reason", "session belongs to another user")
+		return "", status.Errorf(codes.PermissionDenied, "session does not belong to %s", username)
+	}
+	return tokens[0], nil
+}
+
+// unaryInterceptor checks the session of calls in sessionMethods, and tracks
+// which connections each session registered.
+func (v *sessionValidator) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	r, ok := req.(interface{ GetUsername() string })
+	if !ok || !sessionMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	token, err := v.check(ctx, info.FullMethod, r.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	if c, ok := req.(interface{ GetConnectionId() string }); ok && err == nil && c.GetConnectionId() != "" {
+		switch info.FullMethod {
+		case pb.PresenceService_UserConnected_FullMethodName, pb.PresenceService_Heartbeat_FullMethodName:
+			v.bind(token, r.GetUsername(), c.GetConnectionId())
+		case pb.PresenceService_UserDisconnected_FullMethodName:
+			v.unbind(token, c.GetConnectionId())
+		}
+	}
+	return resp, err
+}
+
+// bind records that connID was registered under token. Connections without
+// an ID cannot be told apart and are not tracked.
+func (v *sessionValidator) bind(token, username, connID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	b, ok := v.bindings[token]
+	if !ok {
+		b = &sessionBinding{username: username, conns: map[string]time.Time{}}
+		v.bindings[token] = b
+	}
+	b.conns[connID] = time.Now()
+}
+
+func (v *sessionValidator) unbind(token, connID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if b, ok := v.bindings[token]; ok {
+		delete(b.conns, connID)
+		if len(b.conns) == 0 {
+			delete(v.bindings, token)
+		}
+	}
+}
+
+// endSession forgets token and disconnects the connections registered under it.
+func (v *sessionValidator) endSession(b backend, token string) {
+	v.mu.Lock()
+	binding := v.bindings[token]
+	delete(v.bindings, token)
+	delete(v.cache, token)
+	v.mu.Unlock()
+	if binding == nil {
+		return
+	}
+	for connID := range binding.conns {
+		if err := b.disconnect(binding.username, connID); err != nil {
+			slog.Error("failed to disconnect ended session", "username", binding.username, "connection_id", connID, "error", err)
+		}
+	}
+	slog.Info("session ended, connections dropped", "username", binding.username, "connections", len(binding.conns))
+}
+
+// watch subscribes to Redis keyspace notifications for session keys and ends
+// every session that is deleted, e.g. by a logout, or expires, until done is
+// closed. Notifications are enabled on the server if they are not already.
+func (v *sessionValidator) watch(b backend, done <-chan struct{}) {
+	ctx := context.Background()
+	if err := v.enableNotifications(ctx); err != nil {
+		slog.Warn("failed to enable keyspace notifications, set notify-keyspace-events to include Kgx", "error", err)
+	}
+	channel := fmt.Sprintf("__keyspace@%d__:%s", v.rdb.Options().DB, v.prefix)
+	pubsub := v.rdb.PSubscribe(ctx, channel+"*")
+	go func() {
+		<-done
+		pubsub.Close()
+	}()
+	for msg := range pubsub.Channel() {
+		switch msg.Payload {
+		case "del", "expired":
+			v.endSession(b, strings.TrimPrefix(msg.Channel, channel))
+		}
+	}
+}
+
+// enableNotifications adds the keyspace (K), generic (g) and expired (x)
+// event classes to the server's notify-keyspace-events, keeping the others.
+func (v *sessionValidator) enableNotifications(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	current, err := v.rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	flags := current["notify-keyspace-events"]
+	want := flags
+	for _, f := range "Kgx" {
+		if !strings.ContainsRune(flags, f) && !(f != 'K' && strings.ContainsRune(flags, 'A')) {
+			want += string(f)
+		}
+	}
+	if want == flags {
+		return nil
+	}
+	return v.rdb.ConfigSet(ctx, "notify-keyspace-events", want).Err()
+}
+
+// prune drops expired cache entries, and the bindings of connections that are
+// gone from b without a UserDisconnected, every interval until done is closed.
+func (v *sessionValidator) prune(b backend, interval time.Duration, done <-chan struct{}) {
+	runSweep(interval, done, func() {
+		v.pruneCache()
+		if err := v.pruneBindings(b); err != nil {
+			slog.Error("failed to prune session bindings", "error", err)
+		}
+	})
+}
+
+func (v *sessionValidator) pruneCache() {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for token, c := range v.cache {
+		if !now.Before(c.expires) {
+			delete(v.cache, token)
+		}
+	}
+}
+
+// pruneBindings forgets bound connections that no longer exist in b, e.g.
+// because their lease expired, their node was purged or an admin dropped them.
+// Connections bound while b is read are kept, since they may not show yet.
+func (v *sessionValidator) pruneBindings(b backend) error {
+	start := time.Now()
+	v.mu.Lock()
+	users := map[string]struct{}{}
+	for _, sb := range v.bindings {
+		users[sb.username] = struct{}{}
+	}
+	v.mu.Unlock()
+
+	live := map[string]struct{}{}
+	for u := range users {
+		conns, err := b.userConnections(u)
+		if err != nil {
+			return err
+		}
+		for _, c := range conns {
+			live[c.id] = struct{}{}
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for token, sb := range v.bindings {
+		for id, bound := range sb.conns {
+			if _, ok := live[id]; !ok && bound.Before(start) {
+				delete(sb.conns, id)
+			}
+		}
+		if len(sb.conns) == 0 {
+			delete(v.bindings, token)
+		}
+	}
+	return nil
+}
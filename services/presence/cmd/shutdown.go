@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// drain takes the server out of service: it reports NOT_SERVING, waits
+// c.PreStopDelay for load balancers to stop sending it calls, and ends every
+// watch with a going-away event so that watchers resubscribe elsewhere. A
+// second signal on quit cuts the delay short. It then stops the servers.
+func drain(c shutdownConfig, healthSrv *health.Server, store backend, srv *grpc.Server, httpSrv *http.Server, shared bool, quit <-chan os.Signal) {
+	healthSrv.Shutdown()
+	if d := c.PreStopDelay.Duration; d > 0 {
+		slog.Info("reporting NOT_SERVING before draining", "delay", d)
+		select {
+		case <-time.After(d):
+		case sig := <-quit:
+			slog.Info("draining now", "signal", sig.String())
+		}
+	}
+	store.goAway()
+	stopServers(srv, httpSrv, shared, c.Timeout.Duration)
+}
+
+// stopServers stops accepting calls and waits up to timeout for those in
+// flight, then cuts off the rest. gRPC served through the HTTP server cannot
+// be drained by srv, so in that case the HTTP server waits for it and srv is
+// only stopped afterwards.
+func stopServers(srv *grpc.Server, httpSrv *http.Server, shared bool, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	if httpSrv != nil {
+		wg.Go(func() {
+			if err := httpSrv.Shutdown(ctx); err != nil {
+				slog.Warn("HTTP calls still running at the shutdown timeout, closing them", "timeout", timeout)
+				httpSrv.Close()
+			}
+		})
+	}
+	if shared {
+		wg.Wait()
+		srv.Stop()
+		return
+	}
+	wg.Go(func() {
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			slog.Warn("gRPC calls still running at the shutdown timeout, closing them", "timeout", timeout)
+			srv.Stop()
+			<-stopped
+		}
+	})
+	wg.Wait()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestDrain(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		name := "grpc"
+		if shared {
+			name = "shared"
+		}
+		t.Run(name, func(t *testing.T) { testDrain(t, shared) })
+	}
+}
+
+func TestGoAwaySlowWatcher(t *testing.T) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	snap, events, cancel, _ := s.watch("", "")
+	defer cancel()
+	for i := range watcherBuffer {
+		s.connect(connection{username: fmt.Sprint("user-", i)}, "")
+	}
+
+	// The full buffer is taken back, and the watcher told to resume before it
+	s.goAway()
+	ev, ok := <-events
+	if !ok || ev.typ != eventGoingAway || ev.seq != snap.seq {
+		t.Fatalf("expected going-away to resume after %d, got %+v", snap.seq, ev)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("expected the watcher closed after going-away")
+	}
+}
+
+func testDrain(t *testing.T, shared bool) {
+	s := newStore(defaultTunables())
+	defer s.stopCleanup()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	presence := &server{store: s}
+	pb.RegisterPresenceServiceServer(srv, presence)
+	healthSrv := health.NewServer()
+	healthpb.RegisterHealthServer(srv, healthSrv)
+	var httpSrv *http.Server
+	if shared {
+		httpSrv = newHTTPServer(grpcOrHTTP(srv, newGateway(presence, nil, nil)))
+		go serveHTTP(httpSrv, lis, nil)
+	} else {
+		go srv.Serve(lis)
+	}
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, healthClient := pb.NewPresenceServiceClient(conn), healthpb.NewHealthClient(conn)
+	ctx := context.Background()
+
+	watch, err := client.WatchPresence(ctx, &pb.WatchPresenceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	// A health watch never ends on its own, so only the timeout stops it.
+	stuck, err := healthClient.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stuck.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := shutdownConfig{PreStopDelay: duration{200 * time.Millisecond}, Timeout: duration{300 * time.Millisecond}}
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		drain(cfg, healthSrv, s, srv, httpSrv, shared, nil)
+		close(done)
+	}()
+
+	if resp, err := stuck.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING during the pre-stop delay, got %v, %v", resp, err)
+	}
+	ev, err := watch.Recv()
+	if err != nil || ev.Type != pb.PresenceEvent_GOING_AWAY {
+		t.Fatalf("expected a going-away event, got %v, %v", ev, err)
+	}
+	if time.Since(start) < cfg.PreStopDelay.Duration {
+		t.Errorf("watchers told to go away after %s, before the pre-stop delay", time.Since(start))
+	}
+	if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the watch to end with Unavailable, got %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("drain did not give up on the stuck call")
+	}
+	if _, err := stuck.Recv(); err == nil {
+		t.Error("stuck call still open after drain")
+	}
+
+	// Watching is over for good.
+	_, events, cancel, _ := s.watch("", "")
+	defer cancel()
+	if ev := <-events; ev.typ != eventGoingAway {
+		t.Errorf("expected a new watcher to be told to go away, got %+v", ev)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment, so that
+// proxies do not close it.
+const sseKeepAlive = 15 * time.Second
+
+// handleEvents serves WatchPresence as Server-Sent Events at GET
+// /presence/events, for consumers that speak neither gRPC nor Connect. Query
+// parameters fill the request, so ?room= limits the feed to a room. Each event
+// is a PresenceEvent in JSON with its seq as ID, so a reconnecting EventSource
+// resumes after the last one it got, through Last-Event-ID.
+func handleEvents(mux *http.ServeMux, impl pb.PresenceServiceServer, chain grpc.StreamServerInterceptor) {
+	method := pb.PresenceService_WatchPresence_FullMethodName
+	info := &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}
+	mux.HandleFunc("GET /presence/events", func(w http.ResponseWriter, r *http.Request) {
+		req := &pb.WatchPresenceRequest{}
+		if err := decodeRequest(r, req); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			after, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid Last-Event-ID %q", id))
+				return
+			}
+			req.ResumeAfter = &after
+		}
+
+		ctx, span := callContext(r, method)
+		defer span.End()
+		ss := &sseStream{sentRequest: sentRequest{req: req}, ctx: ctx, w: w}
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Go(func() { ss.keepAlive(done) })
+		defer wg.Wait()
+		defer close(done)
+
+		err := chain(nil, ss, info, func(_ any, ss grpc.ServerStream) error {
+			in := new(pb.WatchPresenceRequest)
+			if err := ss.RecvMsg(in); err != nil {
+				return err
+			}
+			return impl.WatchPresence(in, &grpc.GenericServerStream[pb.WatchPresenceRequest, pb.PresenceEvent]{ServerStream: ss})
+		})
+		if err != nil && ctx.Err() == nil {
+			span.SetStatus(otelcodes.Error, err.Error())
+			ss.fail(err)
+		}
+	})
+}
+
+// sseStream is the grpc.ServerStream of a WatchPresence call made over SSE.
+// The response starts with the first event, or earlier with SendHeader once
+// the call is accepted, so that a call rejected before then gets an ordinary
+// HTTP error.
+type sseStream struct {
+	sentRequest
+	ctx context.Context
+	w   http.ResponseWriter
+
+	mu      sync.Mutex // guards w and started
+	started bool
+}
+
+func (s *sseStream) Context() context.Context    { return s.ctx }
+func (s *sseStream) SetHeader(metadata.MD) error { return nil }
+func (s *sseStream) SetTrailer(metadata.MD)      {}
+
+// SendHeader starts the response before the first event.
+func (s *sseStream) SendHeader(metadata.MD) error { return s.write("") }
+
+func (s *sseStream) SendMsg(m any) error {
+	ev := m.(*pb.PresenceEvent)
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %d\ndata: %s\n\n", ev.Seq, data))
+}
+
+// fail ends the stream with err: as an HTTP error if no event was sent yet,
+// otherwise as an "error" event, after which clients reconnect.
+func (s *sseStream) fail(err error) {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		writeHTTPError(s.w, err)
+		return
+	}
+	data, merr := protojson.Marshal(status.Convert(err).Proto())
+	if merr != nil {
+		data = []byte(`{"code":13,"message":"internal error"}`)
+	}
+	s.write(fmt.Sprintf("event: error\ndata: %s\n\n", data))
+}
+
+// keepAlive sends a comment every sseKeepAlive once the stream has started,
+// until done is closed.
+func (s *sseStream) keepAlive(done <-chan struct{}) {
+	t := time.NewTicker(sseKeepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			started := s.started
+			s.mu.Unlock()
+			if started {
+				s.write(": keep-alive\n\n")
+			}
+		}
+	}
+}
+
+func (s *sseStream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		h := s.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // stream through nginx unbuffered
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	if _, err := fmt.Fprint(s.w, msg); err != nil {
+		return err
+	}
+	return http.NewResponseController(s.w).Flush()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// presenceStatus mirrors pb.Status.
+type presenceStatus int
+
+const (
+	statusOnline presenceStatus = iota
+	statusAway
+	statusBusy
+	statusInvisible
+)
+
+// userStatus is a user's rich presence. The zero value is plain online.
+type userStatus struct {
+	username  string
+	status    presenceStatus
+	text      string
+	emoji     string
+	expiresAt time.Time // zero means it never expires
+}
+
+func (st userStatus) isDefault() bool {
+	return st.status == statusOnline && st.text == "" && st.emoji == "" && st.expiresAt.IsZero()
+}
+
+// setStatus replaces a user's status. It may be set while offline, e.g. to come
+// online invisibly. Going invisible looks like going offline to everyone but
+// the user, and becoming visible again looks like coming online.
+func (s *store) setStatus(st userStatus) (userStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setStatusLocked(st, time.Now())
+	return s.statusLocked(st.username), nil
+}
+
+// setStatusLocked applies a status change and publishes the transitions it
+// causes. Caller must hold s.mu.
+func (s *store) setStatusLocked(st userStatus, now time.Time) {
+	username := st.username
+	old := s.statusLocked(username)
+	s.statusSetAt[username] = now
+	if st.isDefault() {
+		delete(s.statuses, username)
+	} else {
+		s.statuses[username] = st
+	}
+
+	_, online := s.online[username]
+	var rooms []string
+	for room, members := range s.rooms {
+		if _, ok := members[username]; ok {
+			rooms = append(rooms, room)
+		}
+	}
+	slices.Sort(rooms)
+	var typing []string
+	for k := range s.typing {
+		if k.username == username {
+			typing = append(typing, k.room)
+		}
+	}
+	slices.Sort(typing)
+	if online && old.status != statusInvisible && st.status == statusInvisible {
+		s.lastSeen[username] = now
+	}
+	for _, ev := range statusChangeEvents(old, s.statusLocked(username), online, rooms, typing, now) {
+		s.sendLocked(ev)
+	}
+}
+
+// statusChangeEvents returns the transitions others and the user see when
+// their status changes from old to st while they are in rooms and typing in
+// the typing rooms ("" for global).
+func statusChangeEvents(old, st userStatus, online bool, rooms, typing []string, now time.Time) []event {
+	username := st.username
+	wasVisible := old.status != statusInvisible
+	isVisible := st.status != statusInvisible
+
+	var evs []event
+	if wasVisible && !isVisible {
+		for _, room := range typing {
+			evs = append(evs, event{typ: eventTypingStopped, username: username, room: room, at: now, audience: audienceOthers})
+		}
+	}
+	if online && wasVisible && !isVisible {
+		for _, room := range rooms {
+			evs = append(evs, event{typ: eventLeft, username: username, room: room, at: now, audience: audienceOthers})
+		}
+		evs = append(evs, event{typ: eventOffline, username: username, at: now, audience: audienceOthers})
+	}
+	if online && !wasVisible && isVisible {
+		evs = append(evs, event{typ: eventOnline, username: username, at: now, audience: audienceOthers})
+		for _, room := range rooms {
+			evs = append(evs, event{typ: eventJoined, username: username, room: room, at: now, audience: audienceOthers})
+		}
+	}
+	if !wasVisible && isVisible {
+		for _, room := range typing {
+			evs = append(evs, event{typ: eventTypingStarted, username: username, room: room, at: now, audience: audienceOthers})
+		}
+	}
+
+	aud := audienceAll
+	if !online || !isVisible {
+		aud = audienceSelf
+	}
+	return append(evs, event{typ: eventStatusChanged, username: username, at: now, audience: aud, status: st, rooms: rooms})
+}
+
+// userStatuses returns the status of each given user.
+func (s *store) userStatuses(usernames []string) ([]userStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.statusesLocked(usernames), nil
+}
+
+// statusesLocked returns the status of each given user. Caller must hold s.mu.
+func (s *store) statusesLocked(usernames []string) []userStatus {
+	sts := make([]userStatus, len(usernames))
+	for i, u := range usernames {
+		sts[i] = s.statusLocked(u)
+	}
+	return sts
+}
+
+// statusLocked returns a user's status, defaulting to plain online. Caller must hold s.mu.
+func (s *store) statusLocked(username string) userStatus {
+	if st, ok := s.statuses[username]; ok {
+		return st
+	}
+	return userStatus{username: username}
+}
+
+// visibleLocked reports whether viewer may see username's presence. Invisible
+// users are only visible to themselves. Caller must hold s.mu.
+func (s *store) visibleLocked(username, viewer string) bool {
+	return username == viewer || s.statuses[username].status != statusInvisible
+}
+
+// expireStatuses resets statuses whose expiry has passed back to plain online.
+func (s *store) expireStatuses() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for u, st := range s.statuses {
+		if !st.expiresAt.IsZero() && now.After(st.expiresAt) {
+			s.setStatusLocked(userStatus{username: u}, now)
+		}
+	}
+	return nil
+}
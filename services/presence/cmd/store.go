@@ -1,119 +1,554 @@
 package main
 
 import (
+	"fmt"
+	"log/slog"
+	"maps"
 	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// store holds in-memory presence state: online users and typing status.
+// store holds in-memory presence state: connections, room membership and typing status.
 type store struct {
-	mu          sync.RWMutex
-	online      map[string]int       // username -> connection count
-	typing      map[string]time.Time // username -> last typing timestamp
-	cleanupDone chan struct{}
+	mu           sync.RWMutex
+	conns        map[string]*connection            // connection ID -> connection
+	connClaims   map[string]origins                // connection ID -> cluster peers gossiping it
+	online       map[string]map[string]*connection // username -> connection ID -> connection
+	rooms        map[string]map[string]origins     // room -> online members -> replicas holding the membership
+	typing       map[typingKey]time.Time           // (room, username) -> last typing timestamp
+	typingOrigin map[typingKey]string              // typing statuses gossiped by another replica -> that replica
+	statuses     map[string]userStatus             // username -> non-default status
+	statusSetAt  map[string]time.Time              // username -> when their status last changed, for merging replicas
+	lastSeen     map[string]time.Time              // username -> when they were last visibly online
+	nodes        map[string]time.Time              // registered chat node -> lease deadline
+	anonSeq      uint64
+	seq          uint64 // last published event; starts from the clock, see newStore
+	tunables
+	hub          hub
+	cleanupDone  chan struct{}
+	snapshotPath string // saved on stopCleanup when set
 }
 
-func newStore() *store {
+var _ backend = (*store)(nil)
+
+// origins is the set of replicas a piece of state came from: "" for this one,
+// otherwise the address of a cluster peer that gossiped it.
+type origins map[string]struct{}
+
+func newStore(t tunables) *store {
 	s := &store{
-		online:      make(map[string]int),
-		typing:      make(map[string]time.Time),
-		cleanupDone: make(chan struct{}),
+		conns:        make(map[string]*connection),
+		connClaims:   make(map[string]origins),
+		online:       make(map[string]map[string]*connection),
+		rooms:        make(map[string]map[string]origins),
+		typing:       make(map[typingKey]time.Time),
+		typingOrigin: make(map[typingKey]string),
+		statuses:     make(map[string]userStatus),
+		statusSetAt:  make(map[string]time.Time),
+		lastSeen:     make(map[string]time.Time),
+		nodes:        make(map[string]time.Time),
+		tunables:     t,
+		hub:          newHub(),
+		cleanupDone:  make(chan struct{}),
 	}
+	// Sequence numbers start from the clock, so that one a watcher kept from
+	// an earlier process or another replica is not taken for one of this
+	// store's.
+	s.seq = uint64(time.Now().UnixNano())
 	go s.cleanupTyping()
+	go s.reapLeases()
 	return s
 }
 
-// connect increments the connection count and returns the current online users.
-func (s *store) connect(username string) []string {
+// connect registers a connection, joins room if one is given, and returns the
+// current online users. A connection with an ID is a lease that must be renewed
+// with heartbeat; registering the same ID again only renews it. Without an ID
+// the connection gets a generated one, never expires and can only be removed by
+// an anonymous disconnect, which keeps the old per-user counter semantics.
+func (s *store) connect(c connection, room string) ([]string, error) {
 	s.mu.Lock()
-	s.online[username]++
-	users := s.onlineUsersLocked()
-	s.mu.Unlock()
-	return users
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if c.id == "" {
+		s.anonSeq++
+		c.id = fmt.Sprintf("anon-%d", s.anonSeq)
+		c.anonymous = true
+	}
+	if existing, ok := s.conns[c.id]; ok {
+		if existing.username != c.username {
+			return nil, errConnectionConflict
+		}
+		s.adoptLocked(existing)
+		if !existing.anonymous {
+			existing.expiresAt = now.Add(s.leaseTTL)
+		}
+		existing.provisional = false
+	} else {
+		c.connectedAt = now
+		if !c.anonymous {
+			c.expiresAt = now.Add(s.leaseTTL)
+		}
+		s.addConnLocked(&c, now)
+		connectsTotal.Inc()
+	}
+	if room != "" {
+		s.joinRoomLocked(c.username, room, "", now)
+	}
+	return s.onlineUsersLocked(c.username), nil
+}
+
+// disconnect removes a connection. With an empty connID the user's most recent
+// anonymous connection is removed instead. Unknown connections are ignored, so
+// repeated calls are idempotent. When the user's last connection goes they stop
+// typing everywhere, leave every room and go offline.
+func (s *store) disconnect(username, connID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c *connection
+	if connID != "" {
+		c = s.conns[connID]
+	} else {
+		for _, uc := range s.online[username] {
+			if uc.anonymous && (c == nil || uc.connectedAt.After(c.connectedAt)) {
+				c = uc
+			}
+		}
+	}
+	if c == nil || c.username != username {
+		return nil
+	}
+	s.removeConnLocked(c, time.Now())
+	return nil
 }
 
-// disconnect decrements the connection count, removing the user if it reaches zero.
-func (s *store) disconnect(username string) {
+// heartbeat renews a connection lease and returns its new deadline.
+func (s *store) heartbeat(username, connID string) (time.Time, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if count, exists := s.online[username]; exists && count <= 1 {
+	c, ok := s.conns[connID]
+	if !ok || c.username != username || c.anonymous {
+		return time.Time{}, errUnknownConnection
+	}
+	s.adoptLocked(c)
+	c.expiresAt = time.Now().Add(s.leaseTTL)
+	c.provisional = false
+	return c.expiresAt, nil
+}
+
+// addConnLocked registers a new connection, bringing its user online if it is
+// their first. Caller must hold s.mu.
+func (s *store) addConnLocked(c *connection, now time.Time) {
+	s.conns[c.id] = c
+	userConns, ok := s.online[c.username]
+	if !ok {
+		userConns = make(map[string]*connection)
+		s.online[c.username] = userConns
+		s.publishLocked(eventOnline, c.username, "", now)
+	}
+	userConns[c.id] = c
+}
+
+// adoptLocked takes over a connection gossiped by another replica, e.g. after
+// its chat node switched replicas, along with the user's room memberships.
+// Caller must hold s.mu.
+func (s *store) adoptLocked(c *connection) {
+	if c.origin == "" {
+		return
+	}
+	c.origin = ""
+	for _, members := range s.rooms {
+		if from, ok := members[c.username]; ok {
+			from[""] = struct{}{}
+		}
+	}
+}
+
+// removeConnLocked drops a connection. When it was the user's last connection
+// through its replica, the rooms joined through that replica are left too, and
+// when it was their last connection anywhere the user goes offline. Caller must
+// hold s.mu.
+func (s *store) removeConnLocked(c *connection, now time.Time) {
+	if c.origin == "" {
+		disconnectsTotal.Inc()
+	}
+	delete(s.conns, c.id)
+	userConns := s.online[c.username]
+	delete(userConns, c.id)
+	for _, uc := range userConns {
+		if uc.origin == c.origin {
+			return
+		}
+	}
+
+	username := c.username
+	offline := len(userConns) == 0
+	if offline {
 		delete(s.online, username)
-		delete(s.typing, username)
-	} else if exists {
-		s.online[username]--
+		for k := range s.typing {
+			if k.username == username {
+				delete(s.typing, k)
+				s.publishLocked(eventTypingStopped, username, k.room, now)
+			}
+		}
+	}
+	for room, members := range s.rooms {
+		if from, ok := members[username]; ok {
+			if offline {
+				clear(from)
+			}
+			s.leaveRoomLocked(username, room, c.origin, now)
+		}
+	}
+	if !offline {
+		return
+	}
+	// Invisible users were last seen when they went invisible.
+	if s.visibleLocked(username, "") {
+		s.lastSeen[username] = now
+	}
+	s.publishLocked(eventOffline, username, "", now)
+}
+
+// lastSeenUsers returns last-seen times for the given users. Visibly online
+// users are seen now; invisible users report when they went invisible.
+func (s *store) lastSeenUsers(usernames []string) ([]seen, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	result := make([]seen, len(usernames))
+	for i, u := range usernames {
+		if _, ok := s.online[u]; ok && s.visibleLocked(u, "") {
+			result[i] = seen{username: u, online: true, at: now}
+		} else {
+			result[i] = seen{username: u, at: s.lastSeen[u]}
+		}
+	}
+	return result, nil
+}
+
+// presence returns the presence of each given user as seen by viewer. An
+// invisible user looks entirely offline to anyone but themselves.
+func (s *store) presence(usernames []string, viewer string) ([]userPresence, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	result := make([]userPresence, len(usernames))
+	for i, u := range usernames {
+		p := userPresence{username: u, status: userStatus{username: u}, lastSeen: s.lastSeen[u]}
+		if s.visibleLocked(u, viewer) {
+			p.status = s.statusLocked(u)
+			p.connections = len(s.online[u])
+			p.online = p.connections > 0
+			for k := range s.typing {
+				if k.username == u {
+					p.typingRooms = append(p.typingRooms, k.room)
+				}
+			}
+			slices.Sort(p.typingRooms)
+			if p.online {
+				p.lastSeen = now
+			}
+		}
+		result[i] = p
+	}
+	return result, nil
+}
+
+// userConnections returns a user's live connections, oldest first.
+func (s *store) userConnections(username string) ([]connection, error) {
+	s.mu.RLock()
+	conns := make([]connection, 0, len(s.online[username]))
+	for _, c := range s.online[username] {
+		conns = append(conns, *c)
+	}
+	s.mu.RUnlock()
+	sortConnections(conns)
+	return conns, nil
+}
+
+// connection returns the connection with ID connID, or errUnknownConnection.
+func (s *store) connection(connID string) (connection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.conns[connID]
+	if !ok {
+		return connection{}, errUnknownConnection
+	}
+	return *c, nil
+}
+
+// sortConnections orders connections oldest first.
+func sortConnections(conns []connection) {
+	slices.SortFunc(conns, func(a, b connection) int {
+		if n := a.connectedAt.Compare(b.connectedAt); n != 0 {
+			return n
+		}
+		return strings.Compare(a.id, b.id)
+	})
+}
+
+// joinRoom adds an online user to a room and returns the room's online members.
+func (s *store) joinRoom(username, room string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.online[username]; !ok {
+		return nil, errNotOnline
+	}
+	s.joinRoomLocked(username, room, "", time.Now())
+	return s.roomOnlineUsersLocked(room, username), nil
+}
+
+// leaveRoom removes a user from a room, clearing their typing status there.
+func (s *store) leaveRoom(username, room string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	k := typingKey{room: room, username: username}
+	if _, ok := s.typing[k]; ok {
+		delete(s.typing, k)
+		s.publishLocked(eventTypingStopped, username, room, now)
+	}
+	if _, ok := s.rooms[room][username][""]; ok {
+		s.leaveRoomLocked(username, room, "", now)
+	}
+	return nil
+}
+
+// joinRoomLocked records that origin put username in room. Caller must hold s.mu.
+func (s *store) joinRoomLocked(username, room, origin string, now time.Time) {
+	members, ok := s.rooms[room]
+	if !ok {
+		members = make(map[string]origins)
+		s.rooms[room] = members
+	}
+	from, ok := members[username]
+	if !ok {
+		from = make(origins)
+		members[username] = from
+		s.publishLocked(eventJoined, username, room, now)
+	}
+	from[origin] = struct{}{}
+}
+
+// leaveRoomLocked drops origin's hold on username's membership of room. The
+// user leaves once no replica holds it, and empty rooms are dropped. Caller
+// must hold s.mu.
+func (s *store) leaveRoomLocked(username, room, origin string, now time.Time) {
+	from := s.rooms[room][username]
+	delete(from, origin)
+	if len(from) > 0 {
+		return
+	}
+	delete(s.rooms[room], username)
+	if len(s.rooms[room]) == 0 {
+		delete(s.rooms, room)
 	}
+	s.publishLocked(eventLeft, username, room, now)
 }
 
-func (s *store) setTyping(username string, isTyping bool) {
+// setTyping records or clears a typing status in room ("" for global).
+func (s *store) setTyping(username, room string, isTyping bool) error {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	k := typingKey{room: room, username: username}
+	_, wasTyping := s.typing[k]
 	if isTyping {
-		s.typing[username] = time.Now()
-	} else {
-		delete(s.typing, username)
+		s.typing[k] = now
+		delete(s.typingOrigin, k)
+		if !wasTyping {
+			s.publishLocked(eventTypingStarted, username, room, now)
+		}
+	} else if wasTyping {
+		delete(s.typing, k)
+		s.publishLocked(eventTypingStopped, username, room, now)
 	}
-	s.mu.Unlock()
+	return nil
 }
 
-func (s *store) onlineUsers() []string {
+// onlineUsers returns the online users as seen by viewer, who may be empty.
+func (s *store) onlineUsers(viewer string) ([]string, error) {
 	s.mu.RLock()
-	users := s.onlineUsersLocked()
+	users := s.onlineUsersLocked(viewer)
 	s.mu.RUnlock()
-	return users
+	return users, nil
 }
 
-// onlineUsersLocked returns sorted online usernames. Caller must hold s.mu.
-func (s *store) onlineUsersLocked() []string {
+// onlineUsersLocked returns sorted online usernames visible to viewer. Caller must hold s.mu.
+func (s *store) onlineUsersLocked(viewer string) []string {
 	users := make([]string, 0, len(s.online))
 	for u := range s.online {
-		users = append(users, u)
+		if s.visibleLocked(u, viewer) {
+			users = append(users, u)
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+func (s *store) roomOnlineUsers(room, viewer string) ([]string, error) {
+	s.mu.RLock()
+	users := s.roomOnlineUsersLocked(room, viewer)
+	s.mu.RUnlock()
+	return users, nil
+}
+
+// roomOnlineUsersLocked returns sorted online members of room visible to viewer. Caller must hold s.mu.
+func (s *store) roomOnlineUsersLocked(room, viewer string) []string {
+	users := make([]string, 0, len(s.rooms[room]))
+	for u := range s.rooms[room] {
+		if s.visibleLocked(u, viewer) {
+			users = append(users, u)
+		}
 	}
 	slices.Sort(users)
 	return users
 }
 
-func (s *store) typingUsers() []string {
+// typingUsers returns everyone typing in any room, as seen by viewer.
+func (s *store) typingUsers(viewer string) ([]string, error) {
 	s.mu.RLock()
+	users := s.typingUsersLocked(viewer)
+	s.mu.RUnlock()
+	return users, nil
+}
+
+// typingUsersLocked returns sorted, deduplicated typing usernames visible to viewer. Caller must hold s.mu.
+func (s *store) typingUsersLocked(viewer string) []string {
 	users := make([]string, 0, len(s.typing))
-	for u := range s.typing {
-		users = append(users, u)
+	for k := range s.typing {
+		if s.visibleLocked(k.username, viewer) {
+			users = append(users, k.username)
+		}
 	}
+	slices.Sort(users)
+	return slices.Compact(users)
+}
+
+func (s *store) roomTypingUsers(room, viewer string) ([]string, error) {
+	s.mu.RLock()
+	users := s.roomTypingUsersLocked(room, viewer)
 	s.mu.RUnlock()
+	return users, nil
+}
+
+// roomTypingUsersLocked returns sorted usernames typing in room visible to viewer. Caller must hold s.mu.
+func (s *store) roomTypingUsersLocked(room, viewer string) []string {
+	var users []string
+	for k := range s.typing {
+		if k.room == room && s.visibleLocked(k.username, viewer) {
+			users = append(users, k.username)
+		}
+	}
 	slices.Sort(users)
 	return users
 }
 
 // cleanupTyping periodically removes expired typing statuses.
 func (s *store) cleanupTyping() {
-	ticker := time.NewTicker(time.Second)
-	defer func() {
-		ticker.Stop()
-		close(s.cleanupDone)
-	}()
-
-	for {
-		select {
-		case <-ticker.C:
-			s.cleanupExpiredTyping()
-		case <-s.cleanupDone:
-			return
+	runSweep(s.sweepInterval, s.cleanupDone, func() { s.cleanupExpiredTyping() })
+}
+
+func (s *store) cleanupExpiredTyping() error {
+	defer prometheus.NewTimer(typingSweepDuration).ObserveDuration()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range s.typing {
+		if now.Sub(t) > s.typingTimeout {
+			delete(s.typing, k)
+			s.publishLocked(eventTypingExpired, k.username, k.room, now)
+			if _, remote := s.typingOrigin[k]; !remote {
+				typingExpirationsTotal.Inc()
+			}
 		}
 	}
+	return nil
 }
 
-func (s *store) cleanupExpiredTyping() {
+func (s *store) stats() (storeStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return storeStats{users: len(s.online), connections: len(s.conns), typing: len(s.typing)}, nil
+}
+
+func (s *store) dump() (stateDump, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := stateDump{
+		connections: make([]connection, 0, len(s.conns)),
+		typing:      maps.Clone(s.typing),
+		statuses:    make([]userStatus, 0, len(s.statuses)),
+		rooms:       make(map[string][]string, len(s.rooms)),
+		nodes:       maps.Clone(s.nodes),
+	}
+	for _, c := range s.conns {
+		d.connections = append(d.connections, *c)
+	}
+	sortConnections(d.connections)
+	for _, st := range s.statuses {
+		d.statuses = append(d.statuses, st)
+	}
+	slices.SortFunc(d.statuses, func(a, b userStatus) int { return strings.Compare(a.username, b.username) })
+	for room, members := range s.rooms {
+		d.rooms[room] = slices.Sorted(maps.Keys(members))
+	}
+	return d, nil
+}
+
+func (s *store) setTypingTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.typingTimeout = d
+}
+
+// reapLeases periodically removes connections and chat nodes whose lease has
+// expired and resets expired statuses.
+func (s *store) reapLeases() {
+	runSweep(s.sweepInterval, s.cleanupDone, func() {
+		s.reapExpiredLeases()
+		s.reapExpiredNodes()
+		s.expireStatuses()
+	})
+}
+
+// reapExpiredLeases disconnects expired connections exactly as an explicit
+// disconnect would, emitting the same offline transitions.
+func (s *store) reapExpiredLeases() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	now := time.Now()
-	for u, t := range s.typing {
-		// Increased timeout from 5s to 8s for more realistic typing behavior
-		if now.Sub(t) > 8*time.Second {
-			delete(s.typing, u)
+	for _, c := range s.conns {
+		if !c.expiresAt.IsZero() && now.After(c.expiresAt) {
+			if to, ok := s.claimantLocked(c.id); ok {
+				s.transferLocked(c, to, now)
+				continue
+			}
+			s.removeConnLocked(c, now)
+			slog.Info("connection lease expired", "username", c.username, "connection_id", c.id, "node", c.node)
 		}
 	}
+	return nil
 }
 
+// stopCleanup stops the background sweeps and saves a final snapshot if
+// snapshots are enabled.
 func (s *store) stopCleanup() {
 	close(s.cleanupDone)
+	if s.snapshotPath != "" {
+		if err := s.saveSnapshot(s.snapshotPath); err != nil {
+			slog.Error("failed to save snapshot", "path", s.snapshotPath, "error", err)
+		}
+	}
 }
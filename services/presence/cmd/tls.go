@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate, key and CAs from disk and picks up
+// rotated files, e.g. a renewed Kubernetes secret, without a restart. Files are
+// checked every interval; a rotation that fails to load keeps the previous ones.
+type certReloader struct {
+	certFile, keyFile, caFile, peerCAFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool // nil unless caFile is set
+	peerPool *x509.CertPool // nil unless peerCAFile is set
+	modTime  time.Time      // newest modification time of the loaded files
+}
+
+// newCertReloader loads the certificate and key, the client CA if caFile is
+// set and the cluster peer CA if peerCAFile is set.
+func newCertReloader(certFile, keyFile, caFile, peerCAFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, peerCAFile: peerCAFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the files again if any of them changed since the last load.
+func (r *certReloader) reload() error {
+	modTime, err := r.newestModTime()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := !modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	pool, err := loadCA(r.caFile, "client CA")
+	if err != nil {
+		return err
+	}
+	peerPool, err := loadCA(r.peerCAFile, "peer CA")
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	first := r.cert == nil
+	r.cert, r.pool, r.peerPool, r.modTime = &cert, pool, peerPool, modTime
+	r.mu.Unlock()
+	if !first {
+		slog.Info("reloaded TLS certificates", "cert", r.certFile)
+	}
+	return nil
+}
+
+// loadCA reads the CA certificates in file, if set.
+func loadCA(file, what string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", what, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("load %s: no certificates found in %s", what, file)
+	}
+	return pool, nil
+}
+
+func (r *certReloader) newestModTime() (time.Time, error) {
+	var newest time.Time
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile, r.peerCAFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// watch reloads rotated files every interval until done is closed.
+func (r *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	runSweep(interval, done, func() {
+		if err := r.reload(); err != nil {
+			slog.Error("failed to reload TLS certificates, keeping the current ones", "error", err)
+		}
+	})
+}
+
+// serverConfig requires and verifies client certificates when a client CA is
+// configured. Every handshake uses the most recently loaded files.
+func (r *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.pool != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = r.pool
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// clientConfig is used to dial cluster peers, whose certificates must chain to
+// the peer CA, or to the client CA when no peer CA is set. Peers are addressed
+// by pod IP, so only the chain is verified, not the host name.
+func (r *certReloader) clientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		InsecureSkipVerify: true, // replaced by VerifyConnection below
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			r.mu.RLock()
+			pool := r.peerPool
+			if pool == nil {
+				pool = r.pool
+			}
+			r.mu.RUnlock()
+			if pool == nil {
+				return errors.New("no CA configured to verify peers")
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("peer presented no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, c := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			return err
+		},
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for cn, valid for localhost.
+func (ca *testCA) issue(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// clientTLS returns client credentials presenting a certificate for cn, or none if cn is empty.
+func (ca *testCA) clientTLS(t *testing.T, cn string) credentials.TransportCredentials {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	if cn != "" {
+		certPEM, keyPEM := ca.issue(t, cn)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(cfg)
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modTime, modTime)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	certPEM, keyPEM := ca.issue(t, "presence")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, certPEM, start)
+	writeFile(t, keyFile, keyPEM, start)
+	writeFile(t, caFile, ca.pem, start)
+
+	r, err := newCertReloader(certFile, keyFile, caFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record the identity the handlers see
+	callers := make(chan caller, 10)
+	record := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		c, _ := callerFrom(ctx)
+		callers <- c
+		return handler(ctx, req)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(r.serverConfig())),
+		grpc.ChainUnaryInterceptor(identityUnaryInterceptor, record),
+	)
+	pb.RegisterPresenceServiceServer(srv, &server{store: newStore(defaultTunables())})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	call := func(creds credentials.TransportCredentials) (string, error) {
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var p peer.Peer
+		_, err = pb.NewPresenceServiceClient(conn).GetOnlineUsers(context.Background(), &pb.OnlineUsersRequest{}, grpc.Peer(&p))
+		if err != nil {
+			return "", err
+		}
+		return p.AuthInfo.(credentials.TLSInfo).State.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	served, err := call(ca.clientTLS(t, "chat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := <-callers; c.name != "chat" || served != "presence" {
+		t.Fatalf("expected chat calling presence, got %+v calling %s", c, served)
+	}
+	if _, err := call(ca.clientTLS(t, "")); err == nil {
+		t.Fatal("expected a client without a certificate to be rejected")
+	}
+
+	// A rotated certificate is served without a restart
+	certPEM, keyPEM = ca.issue(t, "presence-rotated")
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if served, err := call(ca.clientTLS(t, "chat")); err != nil || served != "presence-rotated" {
+		t.Fatalf("expected the rotated certificate, got %q, %v", served, err)
+	}
+
+	// A broken rotation keeps the working certificate
+	writeFile(t, keyFile, []byte("garbage"), time.Now().Add(time.Second))
+	if err := r.reload(); err == nil {
+		t.Fatal("expected a broken key to fail to load")
+	}
+	if served, err := call(ca.clientTLS(t, "chat")); err != nil || served != "presence-rotated" {
+		t.Fatalf("expected the previous certificate to stay, got %q, %v", served, err)
+	}
+}
+
+func TestClusterTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem, time.Now())
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Replicas serve TLS without requiring client certificates, and verify
+	// each other against the peer CA
+	lis := make([]net.Listener, 2)
+	addrs := make([]string, 2)
+	for i := range lis {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis[i], addrs[i] = l, l.Addr().String()
+	}
+	replicas := make([]*testReplica, 2)
+	for i := range replicas {
+		certFile, keyFile := filepath.Join(dir, fmt.Sprint(i, ".crt")), filepath.Join(dir, fmt.Sprint(i, ".key"))
+		certPEM, keyPEM := ca.issue(t, fmt.Sprint("presence-", i))
+		writeFile(t, certFile, certPEM, time.Now())
+		writeFile(t, keyFile, keyPEM, time.Now())
+		serverTLS, peers, err := transportCredentials(tlsConfig{CertFile: certFile, KeyFile: keyFile, PeerCAFile: caFile, ReloadInterval: duration{time.Hour}}, stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newStore(defaultTunables())
+		c := newCluster(s, addrs[i], addrs, 20*time.Millisecond, 300*time.Millisecond, peers)
+		srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+		pb.RegisterPresenceClusterServer(srv, c)
+		go srv.Serve(lis[i])
+		c.start()
+		replicas[i] = &testReplica{store: s, cluster: c, srv: srv}
+		t.Cleanup(replicas[i].stop)
+	}
+
+	replicas[0].store.connect(connection{id: "s1", username: "alice"}, "")
+	eventually(t, "expected alice gossiped to replica 1 over TLS", func() bool {
+		users, _ := replicas[1].store.onlineUsers("")
+		return slices.Equal(users, []string{"alice"})
+	})
+}
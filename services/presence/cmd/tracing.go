@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+)
+
+// untracedMethods are too frequent and uninteresting to trace.
+var untracedMethods = map[string]bool{
+	pb.PresenceCluster_Gossip_FullMethodName:   true,
+	grpc_health_v1.Health_Check_FullMethodName: true,
+	grpc_health_v1.Health_Watch_FullMethodName: true,
+}
+
+// newTracerProvider exports spans with exporter: "otlp" sends them to the
+// collector configured by the standard OTEL_EXPORTER_OTLP_* variables, and
+// "console" writes them as JSON to out. Sampling follows OTEL_TRACES_SAMPLER.
+func newTracerProvider(ctx context.Context, exporter string, out io.Writer) (*sdktrace.TracerProvider, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "otlp":
+		exp, err = otlptracegrpc.New(ctx)
+	case "console":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the service name.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("presence")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res)), nil
+}
+
+// propagator reads and writes W3C trace context and baggage in gRPC metadata.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// tracingHandler starts a span with tp for every RPC except untracedMethods,
+// continuing the trace of the incoming metadata, if any.
+func tracingHandler(tp trace.TracerProvider) grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(tp),
+		otelgrpc.WithPropagators(propagator),
+		otelgrpc.WithFilter(func(info *stats.RPCTagInfo) bool {
+			return !untracedMethods[info.FullMethodName]
+		}),
+	))
+}
+
+// spanAttributes annotates the RPC's span with the user and room it is about.
+func spanAttributes(ctx context.Context, req any) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if r, ok := req.(interface{ GetUsername() string }); ok && r.GetUsername() != "" {
+		span.SetAttributes(attribute.String("presence.username", r.GetUsername()))
+	}
+	if r, ok := req.(interface{ GetRoom() string }); ok && r.GetRoom() != "" {
+		span.SetAttributes(attribute.String("presence.room", r.GetRoom()))
+	}
+	if r, ok := req.(interface{ GetConnectionId() string }); ok && r.GetConnectionId() != "" {
+		span.SetAttributes(attribute.String("presence.connection_id", r.GetConnectionId()))
+	}
+	if c, ok := callerFrom(ctx); ok {
+		span.SetAttributes(attribute.String("presence.caller", c.name))
+	}
+}
+
+// tracingUnaryInterceptor runs after the caller is identified.
+func tracingUnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	spanAttributes(ctx, req)
+	return handler(ctx, req)
+}
+
+// tracingStreamInterceptor is tracingUnaryInterceptor for streaming RPCs,
+// which read their request from the stream.
+func tracingStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &tracedStream{ServerStream: ss})
+}
+
+type tracedStream struct {
+	grpc.ServerStream
+}
+
+func (s *tracedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		spanAttributes(s.Context(), m)
+	}
+	return err
+}
+
+// traceOutput opens the file console spans are written to, or returns os.Stdout if path is empty.
+func traceOutput(path string) (io.Writer, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	pb "github.com/adrienschuler/godzilla/gen/presence"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	client := serveBackend(t, newStore(defaultTunables()),
+		tracingHandler(tp),
+		grpc.ChainUnaryInterceptor(tracingUnaryInterceptor),
+	)
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&logs, nil)}))
+
+	// chat's span, propagated in the W3C traceparent header
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-"+traceID+"-"+parentID+"-01")
+	if _, err := client.UserConnected(ctx, &pb.UserRequest{Username: "alice", Room: "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	tp.ForceFlush(context.Background())
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.SpanContext.TraceID().String() != traceID || span.Parent.SpanID().String() != parentID {
+		t.Fatalf("expected the span to continue chat's trace, got trace %s parent %s", span.SpanContext.TraceID(), span.Parent.SpanID())
+	}
+	attrs := attribute.NewSet(span.Attributes...)
+	if v, _ := attrs.Value("presence.username"); v.AsString() != "alice" {
+		t.Fatalf("expected the username attribute, got %v", span.Attributes)
+	}
+	if v, _ := attrs.Value("presence.room"); v.AsString() != "d1" {
+		t.Fatalf("expected the room attribute, got %v", span.Attributes)
+	}
+
+	var record struct {
+		TraceID string `json:"trace_id"`
+		SpanID  string `json:"span_id"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.TraceID != traceID || record.SpanID != span.SpanContext.SpanID().String() {
+		t.Fatalf("expected the log record to carry the span's IDs, got %+v", record)
+	}
+}
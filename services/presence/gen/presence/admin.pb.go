@@ -0,0 +1,451 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v6.33.4
+// source: admin.proto
+
+package presence
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StateDump struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Connections   []*Connection          `protobuf:"bytes,1,rep,name=connections,proto3" json:"connections,omitempty"`
+	Typing        []*TypingState         `protobuf:"bytes,2,rep,name=typing,proto3" json:"typing,omitempty"`
+	Statuses      []*UserStatus          `protobuf:"bytes,3,rep,name=statuses,proto3" json:"statuses,omitempty"` // users with a status other than plain ONLINE
+	Rooms         []*RoomMembers         `protobuf:"bytes,4,rep,name=rooms,proto3" json:"rooms,omitempty"`
+	Nodes         []*ChatNode            `protobuf:"bytes,5,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	DumpedAt      int64                  `protobuf:"varint,6,opt,name=dumped_at,json=dumpedAt,proto3" json:"dumped_at,omitempty"` // unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StateDump) Reset() {
+	*x = StateDump{}
+	mi := &file_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StateDump) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateDump) ProtoMessage() {}
+
+func (x *StateDump) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateDump.ProtoReflect.Descriptor instead.
+func (*StateDump) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StateDump) GetConnections() []*Connection {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+func (x *StateDump) GetTyping() []*TypingState {
+	if x != nil {
+		return x.Typing
+	}
+	return nil
+}
+
+func (x *StateDump) GetStatuses() []*UserStatus {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+func (x *StateDump) GetRooms() []*RoomMembers {
+	if x != nil {
+		return x.Rooms
+	}
+	return nil
+}
+
+func (x *StateDump) GetNodes() []*ChatNode {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *StateDump) GetDumpedAt() int64 {
+	if x != nil {
+		return x.DumpedAt
+	}
+	return 0
+}
+
+type ChatNode struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Node          string                 `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	ExpiresAt     int64                  `protobuf:"varint,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChatNode) Reset() {
+	*x = ChatNode{}
+	mi := &file_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChatNode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChatNode) ProtoMessage() {}
+
+func (x *ChatNode) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChatNode.ProtoReflect.Descriptor instead.
+func (*ChatNode) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ChatNode) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+func (x *ChatNode) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type AdminUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminUserRequest) Reset() {
+	*x = AdminUserRequest{}
+	mi := &file_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminUserRequest) ProtoMessage() {}
+
+func (x *AdminUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminUserRequest.ProtoReflect.Descriptor instead.
+func (*AdminUserRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AdminUserRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+type AdminConnectionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminConnectionRequest) Reset() {
+	*x = AdminConnectionRequest{}
+	mi := &file_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminConnectionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminConnectionRequest) ProtoMessage() {}
+
+func (x *AdminConnectionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminConnectionRequest.ProtoReflect.Descriptor instead.
+func (*AdminConnectionRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AdminConnectionRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+type ClearTypingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"` // only this user's when set
+	Room          *string                `protobuf:"bytes,2,opt,name=room,proto3,oneof" json:"room,omitempty"`   // only this room when set, "" for the global scope
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearTypingRequest) Reset() {
+	*x = ClearTypingRequest{}
+	mi := &file_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearTypingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearTypingRequest) ProtoMessage() {}
+
+func (x *ClearTypingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearTypingRequest.ProtoReflect.Descriptor instead.
+func (*ClearTypingRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ClearTypingRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *ClearTypingRequest) GetRoom() string {
+	if x != nil && x.Room != nil {
+		return *x.Room
+	}
+	return ""
+}
+
+type AdminResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Affected      int32                  `protobuf:"varint,1,opt,name=affected,proto3" json:"affected,omitempty"` // connections dropped or typing statuses stopped
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminResponse) Reset() {
+	*x = AdminResponse{}
+	mi := &file_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminResponse) ProtoMessage() {}
+
+func (x *AdminResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminResponse.ProtoReflect.Descriptor instead.
+func (*AdminResponse) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AdminResponse) GetAffected() int32 {
+	if x != nil {
+		return x.Affected
+	}
+	return 0
+}
+
+var File_admin_proto protoreflect.FileDescriptor
+
+const file_admin_proto_rawDesc = "" +
+	"\n" +
+	"\vadmin.proto\x12\bpresence\x1a\x0epresence.proto\x1a\rcluster.proto\"\x98\x02\n" +
+	"\tStateDump\x126\n" +
+	"\vconnections\x18\x01 \x03(\v2\x14.presence.ConnectionR\vconnections\x12-\n" +
+	"\x06typing\x18\x02 \x03(\v2\x15.presence.TypingStateR\x06typing\x120\n" +
+	"\bstatuses\x18\x03 \x03(\v2\x14.presence.UserStatusR\bstatuses\x12+\n" +
+	"\x05rooms\x18\x04 \x03(\v2\x15.presence.RoomMembersR\x05rooms\x12(\n" +
+	"\x05nodes\x18\x05 \x03(\v2\x12.presence.ChatNodeR\x05nodes\x12\x1b\n" +
+	"\tdumped_at\x18\x06 \x01(\x03R\bdumpedAt\"=\n" +
+	"\bChatNode\x12\x12\n" +
+	"\x04node\x18\x01 \x01(\tR\x04node\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x02 \x01(\x03R\texpiresAt\".\n" +
+	"\x10AdminUserRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\"=\n" +
+	"\x16AdminConnectionRequest\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"R\n" +
+	"\x12ClearTypingRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x17\n" +
+	"\x04room\x18\x02 \x01(\tH\x00R\x04room\x88\x01\x01B\a\n" +
+	"\x05_room\"+\n" +
+	"\rAdminResponse\x12\x1a\n" +
+	"\baffected\x18\x01 \x01(\x05R\baffected2\xe4\x02\n" +
+	"\rPresenceAdmin\x121\n" +
+	"\tDumpState\x12\x0f.presence.Empty\x1a\x13.presence.StateDump\x12E\n" +
+	"\x0eDisconnectUser\x12\x1a.presence.AdminUserRequest\x1a\x17.presence.AdminResponse\x12Q\n" +
+	"\x14DisconnectConnection\x12 .presence.AdminConnectionRequest\x1a\x17.presence.AdminResponse\x12D\n" +
+	"\vClearTyping\x12\x1c.presence.ClearTypingRequest\x1a\x17.presence.AdminResponse\x12@\n" +
+	"\tResetUser\x12\x1a.presence.AdminUserRequest\x1a\x17.presence.AdminResponseB0Z.github.com/adrienschuler/godzilla/gen/presenceb\x06proto3"
+
+var (
+	file_admin_proto_rawDescOnce sync.Once
+	file_admin_proto_rawDescData []byte
+)
+
+func file_admin_proto_rawDescGZIP() []byte {
+	file_admin_proto_rawDescOnce.Do(func() {
+		file_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_admin_proto_rawDesc), len(file_admin_proto_rawDesc)))
+	})
+	return file_admin_proto_rawDescData
+}
+
+var file_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_admin_proto_goTypes = []any{
+	(*StateDump)(nil),              // 0: presence.StateDump
+	(*ChatNode)(nil),               // 1: presence.ChatNode
+	(*AdminUserRequest)(nil),       // 2: presence.AdminUserRequest
+	(*AdminConnectionRequest)(nil), // 3: presence.AdminConnectionRequest
+	(*ClearTypingRequest)(nil),     // 4: presence.ClearTypingRequest
+	(*AdminResponse)(nil),          // 5: presence.AdminResponse
+	(*Connection)(nil),             // 6: presence.Connection
+	(*TypingState)(nil),            // 7: presence.TypingState
+	(*UserStatus)(nil),             // 8: presence.UserStatus
+	(*RoomMembers)(nil),            // 9: presence.RoomMembers
+	(*Empty)(nil),                  // 10: presence.Empty
+}
+var file_admin_proto_depIdxs = []int32{
+	6,  // 0: presence.StateDump.connections:type_name -> presence.Connection
+	7,  // 1: presence.StateDump.typing:type_name -> presence.TypingState
+	8,  // 2: presence.StateDump.statuses:type_name -> presence.UserStatus
+	9,  // 3: presence.StateDump.rooms:type_name -> presence.RoomMembers
+	1,  // 4: presence.StateDump.nodes:type_name -> presence.ChatNode
+	10, // 5: presence.PresenceAdmin.DumpState:input_type -> presence.Empty
+	2,  // 6: presence.PresenceAdmin.DisconnectUser:input_type -> presence.AdminUserRequest
+	3,  // 7: presence.PresenceAdmin.DisconnectConnection:input_type -> presence.AdminConnectionRequest
+	4,  // 8: presence.PresenceAdmin.ClearTyping:input_type -> presence.ClearTypingRequest
+	2,  // 9: presence.PresenceAdmin.ResetUser:input_type -> presence.AdminUserRequest
+	0,  // 10: presence.PresenceAdmin.DumpState:output_type -> presence.StateDump
+	5,  // 11: presence.PresenceAdmin.DisconnectUser:output_type -> presence.AdminResponse
+	5,  // 12: presence.PresenceAdmin.DisconnectConnection:output_type -> presence.AdminResponse
+	5,  // 13: presence.PresenceAdmin.ClearTyping:output_type -> presence.AdminResponse
+	5,  // 14: presence.PresenceAdmin.ResetUser:output_type -> presence.AdminResponse
+	10, // [10:15] is the sub-list for method output_type
+	5,  // [5:10] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_admin_proto_init() }
+func file_admin_proto_init() {
+	if File_admin_proto != nil {
+		return
+	}
+	file_presence_proto_init()
+	file_cluster_proto_init()
+	file_admin_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_admin_proto_rawDesc), len(file_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_admin_proto_goTypes,
+		DependencyIndexes: file_admin_proto_depIdxs,
+		MessageInfos:      file_admin_proto_msgTypes,
+	}.Build()
+	File_admin_proto = out.File
+	file_admin_proto_goTypes = nil
+	file_admin_proto_depIdxs = nil
+}
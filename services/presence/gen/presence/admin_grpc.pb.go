@@ -0,0 +1,293 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.1
+// - protoc             v6.33.4
+// source: admin.proto
+
+package presence
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PresenceAdmin_DumpState_FullMethodName            = "/presence.PresenceAdmin/DumpState"
+	PresenceAdmin_DisconnectUser_FullMethodName       = "/presence.PresenceAdmin/DisconnectUser"
+	PresenceAdmin_DisconnectConnection_FullMethodName = "/presence.PresenceAdmin/DisconnectConnection"
+	PresenceAdmin_ClearTyping_FullMethodName          = "/presence.PresenceAdmin/ClearTyping"
+	PresenceAdmin_ResetUser_FullMethodName            = "/presence.PresenceAdmin/ResetUser"
+)
+
+// PresenceAdminClient is the client API for PresenceAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PresenceAdmin inspects and repairs presence state by hand. Every call needs
+// the admin role and is written to the audit log. Changes go through the same
+// paths as PresenceService calls, so watchers see the usual transitions.
+type PresenceAdminClient interface {
+	// DumpState returns the whole state as the replica answering sees it.
+	DumpState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*StateDump, error)
+	// DisconnectUser drops every connection of a user.
+	DisconnectUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*AdminResponse, error)
+	// DisconnectConnection drops one connection, whoever it belongs to.
+	DisconnectConnection(ctx context.Context, in *AdminConnectionRequest, opts ...grpc.CallOption) (*AdminResponse, error)
+	// ClearTyping stops the matching typing statuses.
+	ClearTyping(ctx context.Context, in *ClearTypingRequest, opts ...grpc.CallOption) (*AdminResponse, error)
+	// ResetUser brings a user's connection count back to zero and their status
+	// back to plain ONLINE, as if they had never connected.
+	ResetUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*AdminResponse, error)
+}
+
+type presenceAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPresenceAdminClient(cc grpc.ClientConnInterface) PresenceAdminClient {
+	return &presenceAdminClient{cc}
+}
+
+func (c *presenceAdminClient) DumpState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*StateDump, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StateDump)
+	err := c.cc.Invoke(ctx, PresenceAdmin_DumpState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceAdminClient) DisconnectUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*AdminResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AdminResponse)
+	err := c.cc.Invoke(ctx, PresenceAdmin_DisconnectUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceAdminClient) DisconnectConnection(ctx context.Context, in *AdminConnectionRequest, opts ...grpc.CallOption) (*AdminResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AdminResponse)
+	err := c.cc.Invoke(ctx, PresenceAdmin_DisconnectConnection_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceAdminClient) ClearTyping(ctx context.Context, in *ClearTypingRequest, opts ...grpc.CallOption) (*AdminResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AdminResponse)
+	err := c.cc.Invoke(ctx, PresenceAdmin_ClearTyping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceAdminClient) ResetUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*AdminResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AdminResponse)
+	err := c.cc.Invoke(ctx, PresenceAdmin_ResetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PresenceAdminServer is the server API for PresenceAdmin service.
+// All implementations must embed UnimplementedPresenceAdminServer
+// for forward compatibility.
+//
+// PresenceAdmin inspects and repairs presence state by hand. Every call needs
+// the admin role and is written to the audit log. Changes go through the same
+// paths as PresenceService calls, so watchers see the usual transitions.
+type PresenceAdminServer interface {
+	// DumpState returns the whole state as the replica answering sees it.
+	DumpState(context.Context, *Empty) (*StateDump, error)
+	// DisconnectUser drops every connection of a user.
+	DisconnectUser(context.Context, *AdminUserRequest) (*AdminResponse, error)
+	// DisconnectConnection drops one connection, whoever it belongs to.
+	DisconnectConnection(context.Context, *AdminConnectionRequest) (*AdminResponse, error)
+	// ClearTyping stops the matching typing statuses.
+	ClearTyping(context.Context, *ClearTypingRequest) (*AdminResponse, error)
+	// ResetUser brings a user's connection count back to zero and their status
+	// back to plain ONLINE, as if they had never connected.
+	ResetUser(context.Context, *AdminUserRequest) (*AdminResponse, error)
+	mustEmbedUnimplementedPresenceAdminServer()
+}
+
+// UnimplementedPresenceAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPresenceAdminServer struct{}
+
+func (UnimplementedPresenceAdminServer) DumpState(context.Context, *Empty) (*StateDump, error) {
+	return nil, status.Error(codes.Unimplemented, "method DumpState not implemented")
+}
+func (UnimplementedPresenceAdminServer) DisconnectUser(context.Context, *AdminUserRequest) (*AdminResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DisconnectUser not implemented")
+}
+func (UnimplementedPresenceAdminServer) DisconnectConnection(context.Context, *AdminConnectionRequest) (*AdminResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DisconnectConnection not implemented")
+}
+func (UnimplementedPresenceAdminServer) ClearTyping(context.Context, *ClearTypingRequest) (*AdminResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ClearTyping not implemented")
+}
+func (UnimplementedPresenceAdminServer) ResetUser(context.Context, *AdminUserRequest) (*AdminResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ResetUser not implemented")
+}
+func (UnimplementedPresenceAdminServer) mustEmbedUnimplementedPresenceAdminServer() {}
+func (UnimplementedPresenceAdminServer) testEmbeddedByValue()                       {}
+
+// UnsafePresenceAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PresenceAdminServer will
+// result in compilation errors.
+type UnsafePresenceAdminServer interface {
+	mustEmbedUnimplementedPresenceAdminServer()
+}
+
+func RegisterPresenceAdminServer(s grpc.ServiceRegistrar, srv PresenceAdminServer) {
+	// If the following call panics, it indicates UnimplementedPresenceAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PresenceAdmin_ServiceDesc, srv)
+}
+
+func _PresenceAdmin_DumpState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceAdminServer).DumpState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceAdmin_DumpState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceAdminServer).DumpState(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceAdmin_DisconnectUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceAdminServer).DisconnectUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceAdmin_DisconnectUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceAdminServer).DisconnectUser(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceAdmin_DisconnectConnection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminConnectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceAdminServer).DisconnectConnection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceAdmin_DisconnectConnection_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceAdminServer).DisconnectConnection(ctx, req.(*AdminConnectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceAdmin_ClearTyping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearTypingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceAdminServer).ClearTyping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceAdmin_ClearTyping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceAdminServer).ClearTyping(ctx, req.(*ClearTypingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceAdmin_ResetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceAdminServer).ResetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceAdmin_ResetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceAdminServer).ResetUser(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PresenceAdmin_ServiceDesc is the grpc.ServiceDesc for PresenceAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PresenceAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "presence.PresenceAdmin",
+	HandlerType: (*PresenceAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DumpState",
+			Handler:    _PresenceAdmin_DumpState_Handler,
+		},
+		{
+			MethodName: "DisconnectUser",
+			Handler:    _PresenceAdmin_DisconnectUser_Handler,
+		},
+		{
+			MethodName: "DisconnectConnection",
+			Handler:    _PresenceAdmin_DisconnectConnection_Handler,
+		},
+		{
+			MethodName: "ClearTyping",
+			Handler:    _PresenceAdmin_ClearTyping_Handler,
+		},
+		{
+			MethodName: "ResetUser",
+			Handler:    _PresenceAdmin_ResetUser_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
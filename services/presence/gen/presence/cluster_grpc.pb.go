@@ -0,0 +1,131 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.1
+// - protoc             v6.33.4
+// source: cluster.proto
+
+package presence
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PresenceCluster_Gossip_FullMethodName = "/presence.PresenceCluster/Gossip"
+)
+
+// PresenceClusterClient is the client API for PresenceCluster service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PresenceCluster is spoken between presence replicas in cluster mode. Each
+// round a replica sends its own state and the freshest state it has heard from
+// every other replica, and merges the same from the reply, so state spreads
+// even between replicas that never talk to each other directly.
+type PresenceClusterClient interface {
+	Gossip(ctx context.Context, in *GossipMessage, opts ...grpc.CallOption) (*GossipMessage, error)
+}
+
+type presenceClusterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPresenceClusterClient(cc grpc.ClientConnInterface) PresenceClusterClient {
+	return &presenceClusterClient{cc}
+}
+
+func (c *presenceClusterClient) Gossip(ctx context.Context, in *GossipMessage, opts ...grpc.CallOption) (*GossipMessage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GossipMessage)
+	err := c.cc.Invoke(ctx, PresenceCluster_Gossip_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PresenceClusterServer is the server API for PresenceCluster service.
+// All implementations must embed UnimplementedPresenceClusterServer
+// for forward compatibility.
+//
+// PresenceCluster is spoken between presence replicas in cluster mode. Each
+// round a replica sends its own state and the freshest state it has heard from
+// every other replica, and merges the same from the reply, so state spreads
+// even between replicas that never talk to each other directly.
+type PresenceClusterServer interface {
+	Gossip(context.Context, *GossipMessage) (*GossipMessage, error)
+	mustEmbedUnimplementedPresenceClusterServer()
+}
+
+// UnimplementedPresenceClusterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPresenceClusterServer struct{}
+
+func (UnimplementedPresenceClusterServer) Gossip(context.Context, *GossipMessage) (*GossipMessage, error) {
+	return nil, status.Error(codes.Unimplemented, "method Gossip not implemented")
+}
+func (UnimplementedPresenceClusterServer) mustEmbedUnimplementedPresenceClusterServer() {}
+func (UnimplementedPresenceClusterServer) testEmbeddedByValue()                         {}
+
+// UnsafePresenceClusterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PresenceClusterServer will
+// result in compilation errors.
+type UnsafePresenceClusterServer interface {
+	mustEmbedUnimplementedPresenceClusterServer()
+}
+
+func RegisterPresenceClusterServer(s grpc.ServiceRegistrar, srv PresenceClusterServer) {
+	// If the following call panics, it indicates UnimplementedPresenceClusterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PresenceCluster_ServiceDesc, srv)
+}
+
+func _PresenceCluster_Gossip_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GossipMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceClusterServer).Gossip(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceCluster_Gossip_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceClusterServer).Gossip(ctx, req.(*GossipMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PresenceCluster_ServiceDesc is the grpc.ServiceDesc for PresenceCluster service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PresenceCluster_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "presence.PresenceCluster",
+	HandlerType: (*PresenceClusterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Gossip",
+			Handler:    _PresenceCluster_Gossip_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "cluster.proto",
+}
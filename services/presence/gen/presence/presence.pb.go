@@ -21,9 +21,135 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Status int32
+
+const (
+	Status_ONLINE    Status = 0
+	Status_AWAY      Status = 1
+	Status_BUSY      Status = 2 // do not disturb
+	Status_INVISIBLE Status = 3 // appears offline to everyone else
+)
+
+// Enum value maps for Status.
+var (
+	Status_name = map[int32]string{
+		0: "ONLINE",
+		1: "AWAY",
+		2: "BUSY",
+		3: "INVISIBLE",
+	}
+	Status_value = map[string]int32{
+		"ONLINE":    0,
+		"AWAY":      1,
+		"BUSY":      2,
+		"INVISIBLE": 3,
+	}
+)
+
+func (x Status) Enum() *Status {
+	p := new(Status)
+	*p = x
+	return p
+}
+
+func (x Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_presence_proto_enumTypes[0].Descriptor()
+}
+
+func (Status) Type() protoreflect.EnumType {
+	return &file_presence_proto_enumTypes[0]
+}
+
+func (x Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Status.Descriptor instead.
+func (Status) EnumDescriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{0}
+}
+
+type PresenceEvent_Type int32
+
+const (
+	PresenceEvent_SNAPSHOT       PresenceEvent_Type = 0
+	PresenceEvent_ONLINE         PresenceEvent_Type = 1
+	PresenceEvent_OFFLINE        PresenceEvent_Type = 2
+	PresenceEvent_TYPING_STARTED PresenceEvent_Type = 3
+	PresenceEvent_TYPING_STOPPED PresenceEvent_Type = 4
+	PresenceEvent_TYPING_EXPIRED PresenceEvent_Type = 5
+	PresenceEvent_ROOM_JOINED    PresenceEvent_Type = 6
+	PresenceEvent_ROOM_LEFT      PresenceEvent_Type = 7
+	PresenceEvent_STATUS_CHANGED PresenceEvent_Type = 8
+	PresenceEvent_GOING_AWAY     PresenceEvent_Type = 9 // the server is shutting down and ends the stream; resubscribe with resume_after
+)
+
+// Enum value maps for PresenceEvent_Type.
+var (
+	PresenceEvent_Type_name = map[int32]string{
+		0: "SNAPSHOT",
+		1: "ONLINE",
+		2: "OFFLINE",
+		3: "TYPING_STARTED",
+		4: "TYPING_STOPPED",
+		5: "TYPING_EXPIRED",
+		6: "ROOM_JOINED",
+		7: "ROOM_LEFT",
+		8: "STATUS_CHANGED",
+		9: "GOING_AWAY",
+	}
+	PresenceEvent_Type_value = map[string]int32{
+		"SNAPSHOT":       0,
+		"ONLINE":         1,
+		"OFFLINE":        2,
+		"TYPING_STARTED": 3,
+		"TYPING_STOPPED": 4,
+		"TYPING_EXPIRED": 5,
+		"ROOM_JOINED":    6,
+		"ROOM_LEFT":      7,
+		"STATUS_CHANGED": 8,
+		"GOING_AWAY":     9,
+	}
+)
+
+func (x PresenceEvent_Type) Enum() *PresenceEvent_Type {
+	p := new(PresenceEvent_Type)
+	*p = x
+	return p
+}
+
+func (x PresenceEvent_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (PresenceEvent_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_presence_proto_enumTypes[1].Descriptor()
+}
+
+func (PresenceEvent_Type) Type() protoreflect.EnumType {
+	return &file_presence_proto_enumTypes[1]
+}
+
+func (x PresenceEvent_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use PresenceEvent_Type.Descriptor instead.
+func (PresenceEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{21, 0}
+}
+
 type UserRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Room          string                 `protobuf:"bytes,2,opt,name=room,proto3" json:"room,omitempty"`                                     // discussion ID; joined on connect when set
+	ConnectionId  string                 `protobuf:"bytes,3,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"` // e.g. socket ID; identifies one connection
+	Node          string                 `protobuf:"bytes,4,opt,name=node,proto3" json:"node,omitempty"`                                     // chat pod owning the connection
+	ClientType    string                 `protobuf:"bytes,5,opt,name=client_type,json=clientType,proto3" json:"client_type,omitempty"`       // e.g. "web", "cli"
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -65,28 +191,56 @@ func (x *UserRequest) GetUsername() string {
 	return ""
 }
 
-type SetTypingRequest struct {
+func (x *UserRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *UserRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *UserRequest) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+func (x *UserRequest) GetClientType() string {
+	if x != nil {
+		return x.ClientType
+	}
+	return ""
+}
+
+type HeartbeatRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
-	IsTyping      bool                   `protobuf:"varint,2,opt,name=is_typing,json=isTyping,proto3" json:"is_typing,omitempty"`
+	ConnectionId  string                 `protobuf:"bytes,2,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SetTypingRequest) Reset() {
-	*x = SetTypingRequest{}
+func (x *HeartbeatRequest) Reset() {
+	*x = HeartbeatRequest{}
 	mi := &file_presence_proto_msgTypes[1]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SetTypingRequest) String() string {
+func (x *HeartbeatRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SetTypingRequest) ProtoMessage() {}
+func (*HeartbeatRequest) ProtoMessage() {}
 
-func (x *SetTypingRequest) ProtoReflect() protoreflect.Message {
+func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_presence_proto_msgTypes[1]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -98,46 +252,46 @@ func (x *SetTypingRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SetTypingRequest.ProtoReflect.Descriptor instead.
-func (*SetTypingRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
 	return file_presence_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *SetTypingRequest) GetUsername() string {
+func (x *HeartbeatRequest) GetUsername() string {
 	if x != nil {
 		return x.Username
 	}
 	return ""
 }
 
-func (x *SetTypingRequest) GetIsTyping() bool {
+func (x *HeartbeatRequest) GetConnectionId() string {
 	if x != nil {
-		return x.IsTyping
+		return x.ConnectionId
 	}
-	return false
+	return ""
 }
 
-type OnlineUsersResponse struct {
+type HeartbeatResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Usernames     []string               `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	ExpiresAt     int64                  `protobuf:"varint,1,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // unix milliseconds
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *OnlineUsersResponse) Reset() {
-	*x = OnlineUsersResponse{}
+func (x *HeartbeatResponse) Reset() {
+	*x = HeartbeatResponse{}
 	mi := &file_presence_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *OnlineUsersResponse) String() string {
+func (x *HeartbeatResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*OnlineUsersResponse) ProtoMessage() {}
+func (*HeartbeatResponse) ProtoMessage() {}
 
-func (x *OnlineUsersResponse) ProtoReflect() protoreflect.Message {
+func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_presence_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -149,39 +303,39 @@ func (x *OnlineUsersResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use OnlineUsersResponse.ProtoReflect.Descriptor instead.
-func (*OnlineUsersResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
 	return file_presence_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *OnlineUsersResponse) GetUsernames() []string {
+func (x *HeartbeatResponse) GetExpiresAt() int64 {
 	if x != nil {
-		return x.Usernames
+		return x.ExpiresAt
 	}
-	return nil
+	return 0
 }
 
-type TypingUsersResponse struct {
+type NodeRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Usernames     []string               `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	Node          string                 `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"` // chat pod name, as sent in UserRequest.node
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *TypingUsersResponse) Reset() {
-	*x = TypingUsersResponse{}
+func (x *NodeRequest) Reset() {
+	*x = NodeRequest{}
 	mi := &file_presence_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *TypingUsersResponse) String() string {
+func (x *NodeRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*TypingUsersResponse) ProtoMessage() {}
+func (*NodeRequest) ProtoMessage() {}
 
-func (x *TypingUsersResponse) ProtoReflect() protoreflect.Message {
+func (x *NodeRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_presence_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -193,38 +347,39 @@ func (x *TypingUsersResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use TypingUsersResponse.ProtoReflect.Descriptor instead.
-func (*TypingUsersResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use NodeRequest.ProtoReflect.Descriptor instead.
+func (*NodeRequest) Descriptor() ([]byte, []int) {
 	return file_presence_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *TypingUsersResponse) GetUsernames() []string {
+func (x *NodeRequest) GetNode() string {
 	if x != nil {
-		return x.Usernames
+		return x.Node
 	}
-	return nil
+	return ""
 }
 
-type Empty struct {
+type NodeResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	ExpiresAt     int64                  `protobuf:"varint,1,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // unix milliseconds
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Empty) Reset() {
-	*x = Empty{}
+func (x *NodeResponse) Reset() {
+	*x = NodeResponse{}
 	mi := &file_presence_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Empty) String() string {
+func (x *NodeResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Empty) ProtoMessage() {}
+func (*NodeResponse) ProtoMessage() {}
 
-func (x *Empty) ProtoReflect() protoreflect.Message {
+func (x *NodeResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_presence_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -236,32 +391,1259 @@ func (x *Empty) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
-func (*Empty) Descriptor() ([]byte, []int) {
+// Deprecated: Use NodeResponse.ProtoReflect.Descriptor instead.
+func (*NodeResponse) Descriptor() ([]byte, []int) {
 	return file_presence_proto_rawDescGZIP(), []int{4}
 }
 
-var File_presence_proto protoreflect.FileDescriptor
+func (x *NodeResponse) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
 
-const file_presence_proto_rawDesc = "" +
-	"\n" +
-	"\x0epresence.proto\x12\bpresence\")\n" +
-	"\vUserRequest\x12\x1a\n" +
-	"\busername\x18\x01 \x01(\tR\busername\"K\n" +
-	"\x10SetTypingRequest\x12\x1a\n" +
-	"\busername\x18\x01 \x01(\tR\busername\x12\x1b\n" +
-	"\tis_typing\x18\x02 \x01(\bR\bisTyping\"3\n" +
-	"\x13OnlineUsersResponse\x12\x1c\n" +
-	"\tusernames\x18\x01 \x03(\tR\tusernames\"3\n" +
-	"\x13TypingUsersResponse\x12\x1c\n" +
-	"\tusernames\x18\x01 \x03(\tR\tusernames\"\a\n" +
-	"\x05Empty2\xd2\x02\n" +
+type PurgeNodeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Connections   int32                  `protobuf:"varint,1,opt,name=connections,proto3" json:"connections,omitempty"` // connections dropped
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PurgeNodeResponse) Reset() {
+	*x = PurgeNodeResponse{}
+	mi := &file_presence_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PurgeNodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeNodeResponse) ProtoMessage() {}
+
+func (x *PurgeNodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeNodeResponse.ProtoReflect.Descriptor instead.
+func (*PurgeNodeResponse) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PurgeNodeResponse) GetConnections() int32 {
+	if x != nil {
+		return x.Connections
+	}
+	return 0
+}
+
+type SetTypingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	IsTyping      bool                   `protobuf:"varint,2,opt,name=is_typing,json=isTyping,proto3" json:"is_typing,omitempty"`
+	Room          string                 `protobuf:"bytes,3,opt,name=room,proto3" json:"room,omitempty"` // discussion ID; empty for global typing
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTypingRequest) Reset() {
+	*x = SetTypingRequest{}
+	mi := &file_presence_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTypingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTypingRequest) ProtoMessage() {}
+
+func (x *SetTypingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTypingRequest.ProtoReflect.Descriptor instead.
+func (*SetTypingRequest) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SetTypingRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *SetTypingRequest) GetIsTyping() bool {
+	if x != nil {
+		return x.IsTyping
+	}
+	return false
+}
+
+func (x *SetTypingRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+type RoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Room          string                 `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	Viewer        string                 `protobuf:"bytes,2,opt,name=viewer,proto3" json:"viewer,omitempty"` // username asking; invisible users only see themselves
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RoomRequest) Reset() {
+	*x = RoomRequest{}
+	mi := &file_presence_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoomRequest) ProtoMessage() {}
+
+func (x *RoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoomRequest.ProtoReflect.Descriptor instead.
+func (*RoomRequest) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RoomRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *RoomRequest) GetViewer() string {
+	if x != nil {
+		return x.Viewer
+	}
+	return ""
+}
+
+type SetStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Status        Status                 `protobuf:"varint,2,opt,name=status,proto3,enum=presence.Status" json:"status,omitempty"`
+	CustomText    string                 `protobuf:"bytes,3,opt,name=custom_text,json=customText,proto3" json:"custom_text,omitempty"`
+	Emoji         string                 `protobuf:"bytes,4,opt,name=emoji,proto3" json:"emoji,omitempty"`
+	ExpiresAt     int64                  `protobuf:"varint,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // unix milliseconds; reset to plain ONLINE afterwards, 0 = never
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetStatusRequest) Reset() {
+	*x = SetStatusRequest{}
+	mi := &file_presence_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetStatusRequest) ProtoMessage() {}
+
+func (x *SetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetStatusRequest.ProtoReflect.Descriptor instead.
+func (*SetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SetStatusRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *SetStatusRequest) GetStatus() Status {
+	if x != nil {
+		return x.Status
+	}
+	return Status_ONLINE
+}
+
+func (x *SetStatusRequest) GetCustomText() string {
+	if x != nil {
+		return x.CustomText
+	}
+	return ""
+}
+
+func (x *SetStatusRequest) GetEmoji() string {
+	if x != nil {
+		return x.Emoji
+	}
+	return ""
+}
+
+func (x *SetStatusRequest) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type UserStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Status        Status                 `protobuf:"varint,2,opt,name=status,proto3,enum=presence.Status" json:"status,omitempty"`
+	CustomText    string                 `protobuf:"bytes,3,opt,name=custom_text,json=customText,proto3" json:"custom_text,omitempty"`
+	Emoji         string                 `protobuf:"bytes,4,opt,name=emoji,proto3" json:"emoji,omitempty"`
+	ExpiresAt     int64                  `protobuf:"varint,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserStatus) Reset() {
+	*x = UserStatus{}
+	mi := &file_presence_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserStatus) ProtoMessage() {}
+
+func (x *UserStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserStatus.ProtoReflect.Descriptor instead.
+func (*UserStatus) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *UserStatus) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UserStatus) GetStatus() Status {
+	if x != nil {
+		return x.Status
+	}
+	return Status_ONLINE
+}
+
+func (x *UserStatus) GetCustomText() string {
+	if x != nil {
+		return x.CustomText
+	}
+	return ""
+}
+
+func (x *UserStatus) GetEmoji() string {
+	if x != nil {
+		return x.Emoji
+	}
+	return ""
+}
+
+func (x *UserStatus) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type OnlineUsersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Viewer        string                 `protobuf:"bytes,1,opt,name=viewer,proto3" json:"viewer,omitempty"` // username asking; invisible users only see themselves
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OnlineUsersRequest) Reset() {
+	*x = OnlineUsersRequest{}
+	mi := &file_presence_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OnlineUsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OnlineUsersRequest) ProtoMessage() {}
+
+func (x *OnlineUsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OnlineUsersRequest.ProtoReflect.Descriptor instead.
+func (*OnlineUsersRequest) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *OnlineUsersRequest) GetViewer() string {
+	if x != nil {
+		return x.Viewer
+	}
+	return ""
+}
+
+type OnlineUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Usernames     []string               `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	Users         []*UserStatus          `protobuf:"bytes,2,rep,name=users,proto3" json:"users,omitempty"` // same order as usernames
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OnlineUsersResponse) Reset() {
+	*x = OnlineUsersResponse{}
+	mi := &file_presence_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OnlineUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OnlineUsersResponse) ProtoMessage() {}
+
+func (x *OnlineUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OnlineUsersResponse.ProtoReflect.Descriptor instead.
+func (*OnlineUsersResponse) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *OnlineUsersResponse) GetUsernames() []string {
+	if x != nil {
+		return x.Usernames
+	}
+	return nil
+}
+
+func (x *OnlineUsersResponse) GetUsers() []*UserStatus {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+type TypingUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Usernames     []string               `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TypingUsersResponse) Reset() {
+	*x = TypingUsersResponse{}
+	mi := &file_presence_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TypingUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TypingUsersResponse) ProtoMessage() {}
+
+func (x *TypingUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TypingUsersResponse.ProtoReflect.Descriptor instead.
+func (*TypingUsersResponse) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *TypingUsersResponse) GetUsernames() []string {
+	if x != nil {
+		return x.Usernames
+	}
+	return nil
+}
+
+type Connection struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Node          string                 `protobuf:"bytes,3,opt,name=node,proto3" json:"node,omitempty"`
+	ClientType    string                 `protobuf:"bytes,4,opt,name=client_type,json=clientType,proto3" json:"client_type,omitempty"`
+	ConnectedAt   int64                  `protobuf:"varint,5,opt,name=connected_at,json=connectedAt,proto3" json:"connected_at,omitempty"` // unix milliseconds
+	Provisional   bool                   `protobuf:"varint,6,opt,name=provisional,proto3" json:"provisional,omitempty"`                    // restored after a restart, not yet confirmed by a heartbeat or reconnect
+	Replica       string                 `protobuf:"bytes,7,opt,name=replica,proto3" json:"replica,omitempty"`                             // cluster peer holding the connection, empty if it is the one answering
+	Anonymous     bool                   `protobuf:"varint,8,opt,name=anonymous,proto3" json:"anonymous,omitempty"`                        // registered without a connection_id, so the id was generated
+	ExpiresAt     int64                  `protobuf:"varint,9,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`       // unix milliseconds of the lease deadline, 0 for anonymous connections
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Connection) Reset() {
+	*x = Connection{}
+	mi := &file_presence_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Connection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Connection) ProtoMessage() {}
+
+func (x *Connection) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Connection.ProtoReflect.Descriptor instead.
+func (*Connection) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Connection) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Connection) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *Connection) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+func (x *Connection) GetClientType() string {
+	if x != nil {
+		return x.ClientType
+	}
+	return ""
+}
+
+func (x *Connection) GetConnectedAt() int64 {
+	if x != nil {
+		return x.ConnectedAt
+	}
+	return 0
+}
+
+func (x *Connection) GetProvisional() bool {
+	if x != nil {
+		return x.Provisional
+	}
+	return false
+}
+
+func (x *Connection) GetReplica() string {
+	if x != nil {
+		return x.Replica
+	}
+	return ""
+}
+
+func (x *Connection) GetAnonymous() bool {
+	if x != nil {
+		return x.Anonymous
+	}
+	return false
+}
+
+func (x *Connection) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type ConnectionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Connections   []*Connection          `protobuf:"bytes,1,rep,name=connections,proto3" json:"connections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectionsResponse) Reset() {
+	*x = ConnectionsResponse{}
+	mi := &file_presence_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectionsResponse) ProtoMessage() {}
+
+func (x *ConnectionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectionsResponse.ProtoReflect.Descriptor instead.
+func (*ConnectionsResponse) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ConnectionsResponse) GetConnections() []*Connection {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+type UsersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Usernames     []string               `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	Viewer        string                 `protobuf:"bytes,2,opt,name=viewer,proto3" json:"viewer,omitempty"` // username asking; invisible users only see themselves
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UsersRequest) Reset() {
+	*x = UsersRequest{}
+	mi := &file_presence_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UsersRequest) ProtoMessage() {}
+
+func (x *UsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UsersRequest.ProtoReflect.Descriptor instead.
+func (*UsersRequest) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *UsersRequest) GetUsernames() []string {
+	if x != nil {
+		return x.Usernames
+	}
+	return nil
+}
+
+func (x *UsersRequest) GetViewer() string {
+	if x != nil {
+		return x.Viewer
+	}
+	return ""
+}
+
+type LastSeen struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Online        bool                   `protobuf:"varint,2,opt,name=online,proto3" json:"online,omitempty"`
+	LastSeen      int64                  `protobuf:"varint,3,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"` // unix milliseconds; now if online, 0 if never seen
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LastSeen) Reset() {
+	*x = LastSeen{}
+	mi := &file_presence_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LastSeen) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LastSeen) ProtoMessage() {}
+
+func (x *LastSeen) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LastSeen.ProtoReflect.Descriptor instead.
+func (*LastSeen) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *LastSeen) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *LastSeen) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+func (x *LastSeen) GetLastSeen() int64 {
+	if x != nil {
+		return x.LastSeen
+	}
+	return 0
+}
+
+type LastSeenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*LastSeen            `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"` // same order as the request
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LastSeenResponse) Reset() {
+	*x = LastSeenResponse{}
+	mi := &file_presence_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LastSeenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LastSeenResponse) ProtoMessage() {}
+
+func (x *LastSeenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LastSeenResponse.ProtoReflect.Descriptor instead.
+func (*LastSeenResponse) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *LastSeenResponse) GetUsers() []*LastSeen {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+type UserPresence struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Username        string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Online          bool                   `protobuf:"varint,2,opt,name=online,proto3" json:"online,omitempty"`
+	Status          *UserStatus            `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	ConnectionCount int32                  `protobuf:"varint,4,opt,name=connection_count,json=connectionCount,proto3" json:"connection_count,omitempty"`
+	Typing          bool                   `protobuf:"varint,5,opt,name=typing,proto3" json:"typing,omitempty"`
+	TypingRooms     []string               `protobuf:"bytes,6,rep,name=typing_rooms,json=typingRooms,proto3" json:"typing_rooms,omitempty"` // rooms typed in; "" is the global scope
+	LastSeen        int64                  `protobuf:"varint,7,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`         // unix milliseconds; now if online, 0 if never seen
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *UserPresence) Reset() {
+	*x = UserPresence{}
+	mi := &file_presence_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserPresence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserPresence) ProtoMessage() {}
+
+func (x *UserPresence) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserPresence.ProtoReflect.Descriptor instead.
+func (*UserPresence) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *UserPresence) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UserPresence) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+func (x *UserPresence) GetStatus() *UserStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *UserPresence) GetConnectionCount() int32 {
+	if x != nil {
+		return x.ConnectionCount
+	}
+	return 0
+}
+
+func (x *UserPresence) GetTyping() bool {
+	if x != nil {
+		return x.Typing
+	}
+	return false
+}
+
+func (x *UserPresence) GetTypingRooms() []string {
+	if x != nil {
+		return x.TypingRooms
+	}
+	return nil
+}
+
+func (x *UserPresence) GetLastSeen() int64 {
+	if x != nil {
+		return x.LastSeen
+	}
+	return 0
+}
+
+type PresenceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*UserPresence        `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"` // same order as the request
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PresenceResponse) Reset() {
+	*x = PresenceResponse{}
+	mi := &file_presence_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PresenceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PresenceResponse) ProtoMessage() {}
+
+func (x *PresenceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PresenceResponse.ProtoReflect.Descriptor instead.
+func (*PresenceResponse) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *PresenceResponse) GetUsers() []*UserPresence {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+type WatchPresenceRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Room   string                 `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`     // only stream this discussion when set
+	Viewer string                 `protobuf:"bytes,2,opt,name=viewer,proto3" json:"viewer,omitempty"` // username watching; invisible users only see themselves
+	// seq of the last event seen: the events after it are replayed instead of
+	// sending a snapshot, if this replica still has them
+	ResumeAfter   *uint64 `protobuf:"varint,3,opt,name=resume_after,json=resumeAfter,proto3,oneof" json:"resume_after,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchPresenceRequest) Reset() {
+	*x = WatchPresenceRequest{}
+	mi := &file_presence_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchPresenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchPresenceRequest) ProtoMessage() {}
+
+func (x *WatchPresenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchPresenceRequest.ProtoReflect.Descriptor instead.
+func (*WatchPresenceRequest) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *WatchPresenceRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *WatchPresenceRequest) GetViewer() string {
+	if x != nil {
+		return x.Viewer
+	}
+	return ""
+}
+
+func (x *WatchPresenceRequest) GetResumeAfter() uint64 {
+	if x != nil && x.ResumeAfter != nil {
+		return *x.ResumeAfter
+	}
+	return 0
+}
+
+type PresenceEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          PresenceEvent_Type     `protobuf:"varint,1,opt,name=type,proto3,enum=presence.PresenceEvent_Type" json:"type,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`    // unset for SNAPSHOT
+	Online        []string               `protobuf:"bytes,3,rep,name=online,proto3" json:"online,omitempty"`        // SNAPSHOT only
+	Typing        []string               `protobuf:"bytes,4,rep,name=typing,proto3" json:"typing,omitempty"`        // SNAPSHOT only
+	Timestamp     int64                  `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix milliseconds
+	Room          string                 `protobuf:"bytes,6,opt,name=room,proto3" json:"room,omitempty"`            // set for room-scoped events
+	Status        *UserStatus            `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`        // STATUS_CHANGED only
+	Statuses      []*UserStatus          `protobuf:"bytes,8,rep,name=statuses,proto3" json:"statuses,omitempty"`    // SNAPSHOT only, one per online user
+	Seq           uint64                 `protobuf:"varint,9,opt,name=seq,proto3" json:"seq,omitempty"`             // position in the change feed; for SNAPSHOT, of the last event it reflects
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PresenceEvent) Reset() {
+	*x = PresenceEvent{}
+	mi := &file_presence_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PresenceEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PresenceEvent) ProtoMessage() {}
+
+func (x *PresenceEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PresenceEvent.ProtoReflect.Descriptor instead.
+func (*PresenceEvent) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *PresenceEvent) GetType() PresenceEvent_Type {
+	if x != nil {
+		return x.Type
+	}
+	return PresenceEvent_SNAPSHOT
+}
+
+func (x *PresenceEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *PresenceEvent) GetOnline() []string {
+	if x != nil {
+		return x.Online
+	}
+	return nil
+}
+
+func (x *PresenceEvent) GetTyping() []string {
+	if x != nil {
+		return x.Typing
+	}
+	return nil
+}
+
+func (x *PresenceEvent) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *PresenceEvent) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *PresenceEvent) GetStatus() *UserStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *PresenceEvent) GetStatuses() []*UserStatus {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+func (x *PresenceEvent) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_presence_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_presence_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_presence_proto_rawDescGZIP(), []int{22}
+}
+
+var File_presence_proto protoreflect.FileDescriptor
+
+const file_presence_proto_rawDesc = "" +
+	"\n" +
+	"\x0epresence.proto\x12\bpresence\"\x97\x01\n" +
+	"\vUserRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x12\n" +
+	"\x04room\x18\x02 \x01(\tR\x04room\x12#\n" +
+	"\rconnection_id\x18\x03 \x01(\tR\fconnectionId\x12\x12\n" +
+	"\x04node\x18\x04 \x01(\tR\x04node\x12\x1f\n" +
+	"\vclient_type\x18\x05 \x01(\tR\n" +
+	"clientType\"S\n" +
+	"\x10HeartbeatRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12#\n" +
+	"\rconnection_id\x18\x02 \x01(\tR\fconnectionId\"2\n" +
+	"\x11HeartbeatResponse\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x01 \x01(\x03R\texpiresAt\"!\n" +
+	"\vNodeRequest\x12\x12\n" +
+	"\x04node\x18\x01 \x01(\tR\x04node\"-\n" +
+	"\fNodeResponse\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x01 \x01(\x03R\texpiresAt\"5\n" +
+	"\x11PurgeNodeResponse\x12 \n" +
+	"\vconnections\x18\x01 \x01(\x05R\vconnections\"_\n" +
+	"\x10SetTypingRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x1b\n" +
+	"\tis_typing\x18\x02 \x01(\bR\bisTyping\x12\x12\n" +
+	"\x04room\x18\x03 \x01(\tR\x04room\"9\n" +
+	"\vRoomRequest\x12\x12\n" +
+	"\x04room\x18\x01 \x01(\tR\x04room\x12\x16\n" +
+	"\x06viewer\x18\x02 \x01(\tR\x06viewer\"\xae\x01\n" +
+	"\x10SetStatusRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12(\n" +
+	"\x06status\x18\x02 \x01(\x0e2\x10.presence.StatusR\x06status\x12\x1f\n" +
+	"\vcustom_text\x18\x03 \x01(\tR\n" +
+	"customText\x12\x14\n" +
+	"\x05emoji\x18\x04 \x01(\tR\x05emoji\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x05 \x01(\x03R\texpiresAt\"\xa8\x01\n" +
+	"\n" +
+	"UserStatus\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12(\n" +
+	"\x06status\x18\x02 \x01(\x0e2\x10.presence.StatusR\x06status\x12\x1f\n" +
+	"\vcustom_text\x18\x03 \x01(\tR\n" +
+	"customText\x12\x14\n" +
+	"\x05emoji\x18\x04 \x01(\tR\x05emoji\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x05 \x01(\x03R\texpiresAt\",\n" +
+	"\x12OnlineUsersRequest\x12\x16\n" +
+	"\x06viewer\x18\x01 \x01(\tR\x06viewer\"_\n" +
+	"\x13OnlineUsersResponse\x12\x1c\n" +
+	"\tusernames\x18\x01 \x03(\tR\tusernames\x12*\n" +
+	"\x05users\x18\x02 \x03(\v2\x14.presence.UserStatusR\x05users\"3\n" +
+	"\x13TypingUsersResponse\x12\x1c\n" +
+	"\tusernames\x18\x01 \x03(\tR\tusernames\"\x89\x02\n" +
+	"\n" +
+	"Connection\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x12\n" +
+	"\x04node\x18\x03 \x01(\tR\x04node\x12\x1f\n" +
+	"\vclient_type\x18\x04 \x01(\tR\n" +
+	"clientType\x12!\n" +
+	"\fconnected_at\x18\x05 \x01(\x03R\vconnectedAt\x12 \n" +
+	"\vprovisional\x18\x06 \x01(\bR\vprovisional\x12\x18\n" +
+	"\areplica\x18\a \x01(\tR\areplica\x12\x1c\n" +
+	"\tanonymous\x18\b \x01(\bR\tanonymous\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\t \x01(\x03R\texpiresAt\"M\n" +
+	"\x13ConnectionsResponse\x126\n" +
+	"\vconnections\x18\x01 \x03(\v2\x14.presence.ConnectionR\vconnections\"D\n" +
+	"\fUsersRequest\x12\x1c\n" +
+	"\tusernames\x18\x01 \x03(\tR\tusernames\x12\x16\n" +
+	"\x06viewer\x18\x02 \x01(\tR\x06viewer\"[\n" +
+	"\bLastSeen\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x16\n" +
+	"\x06online\x18\x02 \x01(\bR\x06online\x12\x1b\n" +
+	"\tlast_seen\x18\x03 \x01(\x03R\blastSeen\"<\n" +
+	"\x10LastSeenResponse\x12(\n" +
+	"\x05users\x18\x01 \x03(\v2\x12.presence.LastSeenR\x05users\"\xf3\x01\n" +
+	"\fUserPresence\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x16\n" +
+	"\x06online\x18\x02 \x01(\bR\x06online\x12,\n" +
+	"\x06status\x18\x03 \x01(\v2\x14.presence.UserStatusR\x06status\x12)\n" +
+	"\x10connection_count\x18\x04 \x01(\x05R\x0fconnectionCount\x12\x16\n" +
+	"\x06typing\x18\x05 \x01(\bR\x06typing\x12!\n" +
+	"\ftyping_rooms\x18\x06 \x03(\tR\vtypingRooms\x12\x1b\n" +
+	"\tlast_seen\x18\a \x01(\x03R\blastSeen\"@\n" +
+	"\x10PresenceResponse\x12,\n" +
+	"\x05users\x18\x01 \x03(\v2\x16.presence.UserPresenceR\x05users\"{\n" +
+	"\x14WatchPresenceRequest\x12\x12\n" +
+	"\x04room\x18\x01 \x01(\tR\x04room\x12\x16\n" +
+	"\x06viewer\x18\x02 \x01(\tR\x06viewer\x12&\n" +
+	"\fresume_after\x18\x03 \x01(\x04H\x00R\vresumeAfter\x88\x01\x01B\x0f\n" +
+	"\r_resume_after\"\xe1\x03\n" +
+	"\rPresenceEvent\x120\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1c.presence.PresenceEvent.TypeR\x04type\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x16\n" +
+	"\x06online\x18\x03 \x03(\tR\x06online\x12\x16\n" +
+	"\x06typing\x18\x04 \x03(\tR\x06typing\x12\x1c\n" +
+	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\x12\x12\n" +
+	"\x04room\x18\x06 \x01(\tR\x04room\x12,\n" +
+	"\x06status\x18\a \x01(\v2\x14.presence.UserStatusR\x06status\x120\n" +
+	"\bstatuses\x18\b \x03(\v2\x14.presence.UserStatusR\bstatuses\x12\x10\n" +
+	"\x03seq\x18\t \x01(\x04R\x03seq\"\xad\x01\n" +
+	"\x04Type\x12\f\n" +
+	"\bSNAPSHOT\x10\x00\x12\n" +
+	"\n" +
+	"\x06ONLINE\x10\x01\x12\v\n" +
+	"\aOFFLINE\x10\x02\x12\x12\n" +
+	"\x0eTYPING_STARTED\x10\x03\x12\x12\n" +
+	"\x0eTYPING_STOPPED\x10\x04\x12\x12\n" +
+	"\x0eTYPING_EXPIRED\x10\x05\x12\x0f\n" +
+	"\vROOM_JOINED\x10\x06\x12\r\n" +
+	"\tROOM_LEFT\x10\a\x12\x12\n" +
+	"\x0eSTATUS_CHANGED\x10\b\x12\x0e\n" +
+	"\n" +
+	"GOING_AWAY\x10\t\"\a\n" +
+	"\x05Empty*7\n" +
+	"\x06Status\x12\n" +
+	"\n" +
+	"\x06ONLINE\x10\x00\x12\b\n" +
+	"\x04AWAY\x10\x01\x12\b\n" +
+	"\x04BUSY\x10\x02\x12\r\n" +
+	"\tINVISIBLE\x10\x032\x91\t\n" +
 	"\x0fPresenceService\x12E\n" +
 	"\rUserConnected\x12\x15.presence.UserRequest\x1a\x1d.presence.OnlineUsersResponse\x12:\n" +
-	"\x10UserDisconnected\x12\x15.presence.UserRequest\x1a\x0f.presence.Empty\x128\n" +
-	"\tSetTyping\x12\x1a.presence.SetTypingRequest\x1a\x0f.presence.Empty\x12@\n" +
-	"\x0eGetOnlineUsers\x12\x0f.presence.Empty\x1a\x1d.presence.OnlineUsersResponse\x12@\n" +
-	"\x0eGetTypingUsers\x12\x0f.presence.Empty\x1a\x1d.presence.TypingUsersResponseB0Z.github.com/adrienschuler/godzilla/gen/presenceb\x06proto3"
+	"\x10UserDisconnected\x12\x15.presence.UserRequest\x1a\x0f.presence.Empty\x12D\n" +
+	"\tHeartbeat\x12\x1a.presence.HeartbeatRequest\x1a\x1b.presence.HeartbeatResponse\x128\n" +
+	"\tSetTyping\x12\x1a.presence.SetTypingRequest\x1a\x0f.presence.Empty\x12=\n" +
+	"\tSetStatus\x12\x1a.presence.SetStatusRequest\x1a\x14.presence.UserStatus\x12M\n" +
+	"\x0eGetOnlineUsers\x12\x1c.presence.OnlineUsersRequest\x1a\x1d.presence.OnlineUsersResponse\x12@\n" +
+	"\x0eGetTypingUsers\x12\x0f.presence.Empty\x1a\x1d.presence.TypingUsersResponse\x12J\n" +
+	"\x12GetUserConnections\x12\x15.presence.UserRequest\x1a\x1d.presence.ConnectionsResponse\x12A\n" +
+	"\vGetLastSeen\x12\x16.presence.UsersRequest\x1a\x1a.presence.LastSeenResponse\x12A\n" +
+	"\vGetPresence\x12\x16.presence.UsersRequest\x1a\x1a.presence.PresenceResponse\x12@\n" +
+	"\bJoinRoom\x12\x15.presence.UserRequest\x1a\x1d.presence.OnlineUsersResponse\x123\n" +
+	"\tLeaveRoom\x12\x15.presence.UserRequest\x1a\x0f.presence.Empty\x12J\n" +
+	"\x12GetRoomOnlineUsers\x12\x15.presence.RoomRequest\x1a\x1d.presence.OnlineUsersResponse\x12J\n" +
+	"\x12GetRoomTypingUsers\x12\x15.presence.RoomRequest\x1a\x1d.presence.TypingUsersResponse\x12J\n" +
+	"\rWatchPresence\x12\x1e.presence.WatchPresenceRequest\x1a\x17.presence.PresenceEvent0\x01\x12=\n" +
+	"\fRegisterNode\x12\x15.presence.NodeRequest\x1a\x16.presence.NodeResponse\x12?\n" +
+	"\tPurgeNode\x12\x15.presence.NodeRequest\x1a\x1b.presence.PurgeNodeResponseB0Z.github.com/adrienschuler/godzilla/gen/presenceb\x06proto3"
 
 var (
 	file_presence_proto_rawDescOnce sync.Once
@@ -275,30 +1657,85 @@ func file_presence_proto_rawDescGZIP() []byte {
 	return file_presence_proto_rawDescData
 }
 
-var file_presence_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_presence_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_presence_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
 var file_presence_proto_goTypes = []any{
-	(*UserRequest)(nil),         // 0: presence.UserRequest
-	(*SetTypingRequest)(nil),    // 1: presence.SetTypingRequest
-	(*OnlineUsersResponse)(nil), // 2: presence.OnlineUsersResponse
-	(*TypingUsersResponse)(nil), // 3: presence.TypingUsersResponse
-	(*Empty)(nil),               // 4: presence.Empty
+	(Status)(0),                  // 0: presence.Status
+	(PresenceEvent_Type)(0),      // 1: presence.PresenceEvent.Type
+	(*UserRequest)(nil),          // 2: presence.UserRequest
+	(*HeartbeatRequest)(nil),     // 3: presence.HeartbeatRequest
+	(*HeartbeatResponse)(nil),    // 4: presence.HeartbeatResponse
+	(*NodeRequest)(nil),          // 5: presence.NodeRequest
+	(*NodeResponse)(nil),         // 6: presence.NodeResponse
+	(*PurgeNodeResponse)(nil),    // 7: presence.PurgeNodeResponse
+	(*SetTypingRequest)(nil),     // 8: presence.SetTypingRequest
+	(*RoomRequest)(nil),          // 9: presence.RoomRequest
+	(*SetStatusRequest)(nil),     // 10: presence.SetStatusRequest
+	(*UserStatus)(nil),           // 11: presence.UserStatus
+	(*OnlineUsersRequest)(nil),   // 12: presence.OnlineUsersRequest
+	(*OnlineUsersResponse)(nil),  // 13: presence.OnlineUsersResponse
+	(*TypingUsersResponse)(nil),  // 14: presence.TypingUsersResponse
+	(*Connection)(nil),           // 15: presence.Connection
+	(*ConnectionsResponse)(nil),  // 16: presence.ConnectionsResponse
+	(*UsersRequest)(nil),         // 17: presence.UsersRequest
+	(*LastSeen)(nil),             // 18: presence.LastSeen
+	(*LastSeenResponse)(nil),     // 19: presence.LastSeenResponse
+	(*UserPresence)(nil),         // 20: presence.UserPresence
+	(*PresenceResponse)(nil),     // 21: presence.PresenceResponse
+	(*WatchPresenceRequest)(nil), // 22: presence.WatchPresenceRequest
+	(*PresenceEvent)(nil),        // 23: presence.PresenceEvent
+	(*Empty)(nil),                // 24: presence.Empty
 }
 var file_presence_proto_depIdxs = []int32{
-	0, // 0: presence.PresenceService.UserConnected:input_type -> presence.UserRequest
-	0, // 1: presence.PresenceService.UserDisconnected:input_type -> presence.UserRequest
-	1, // 2: presence.PresenceService.SetTyping:input_type -> presence.SetTypingRequest
-	4, // 3: presence.PresenceService.GetOnlineUsers:input_type -> presence.Empty
-	4, // 4: presence.PresenceService.GetTypingUsers:input_type -> presence.Empty
-	2, // 5: presence.PresenceService.UserConnected:output_type -> presence.OnlineUsersResponse
-	4, // 6: presence.PresenceService.UserDisconnected:output_type -> presence.Empty
-	4, // 7: presence.PresenceService.SetTyping:output_type -> presence.Empty
-	2, // 8: presence.PresenceService.GetOnlineUsers:output_type -> presence.OnlineUsersResponse
-	3, // 9: presence.PresenceService.GetTypingUsers:output_type -> presence.TypingUsersResponse
-	5, // [5:10] is the sub-list for method output_type
-	0, // [0:5] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: presence.SetStatusRequest.status:type_name -> presence.Status
+	0,  // 1: presence.UserStatus.status:type_name -> presence.Status
+	11, // 2: presence.OnlineUsersResponse.users:type_name -> presence.UserStatus
+	15, // 3: presence.ConnectionsResponse.connections:type_name -> presence.Connection
+	18, // 4: presence.LastSeenResponse.users:type_name -> presence.LastSeen
+	11, // 5: presence.UserPresence.status:type_name -> presence.UserStatus
+	20, // 6: presence.PresenceResponse.users:type_name -> presence.UserPresence
+	1,  // 7: presence.PresenceEvent.type:type_name -> presence.PresenceEvent.Type
+	11, // 8: presence.PresenceEvent.status:type_name -> presence.UserStatus
+	11, // 9: presence.PresenceEvent.statuses:type_name -> presence.UserStatus
+	2,  // 10: presence.PresenceService.UserConnected:input_type -> presence.UserRequest
+	2,  // 11: presence.PresenceService.UserDisconnected:input_type -> presence.UserRequest
+	3,  // 12: presence.PresenceService.Heartbeat:input_type -> presence.HeartbeatRequest
+	8,  // 13: presence.PresenceService.SetTyping:input_type -> presence.SetTypingRequest
+	10, // 14: presence.PresenceService.SetStatus:input_type -> presence.SetStatusRequest
+	12, // 15: presence.PresenceService.GetOnlineUsers:input_type -> presence.OnlineUsersRequest
+	24, // 16: presence.PresenceService.GetTypingUsers:input_type -> presence.Empty
+	2,  // 17: presence.PresenceService.GetUserConnections:input_type -> presence.UserRequest
+	17, // 18: presence.PresenceService.GetLastSeen:input_type -> presence.UsersRequest
+	17, // 19: presence.PresenceService.GetPresence:input_type -> presence.UsersRequest
+	2,  // 20: presence.PresenceService.JoinRoom:input_type -> presence.UserRequest
+	2,  // 21: presence.PresenceService.LeaveRoom:input_type -> presence.UserRequest
+	9,  // 22: presence.PresenceService.GetRoomOnlineUsers:input_type -> presence.RoomRequest
+	9,  // 23: presence.PresenceService.GetRoomTypingUsers:input_type -> presence.RoomRequest
+	22, // 24: presence.PresenceService.WatchPresence:input_type -> presence.WatchPresenceRequest
+	5,  // 25: presence.PresenceService.RegisterNode:input_type -> presence.NodeRequest
+	5,  // 26: presence.PresenceService.PurgeNode:input_type -> presence.NodeRequest
+	13, // 27: presence.PresenceService.UserConnected:output_type -> presence.OnlineUsersResponse
+	24, // 28: presence.PresenceService.UserDisconnected:output_type -> presence.Empty
+	4,  // 29: presence.PresenceService.Heartbeat:output_type -> presence.HeartbeatResponse
+	24, // 30: presence.PresenceService.SetTyping:output_type -> presence.Empty
+	11, // 31: presence.PresenceService.SetStatus:output_type -> presence.UserStatus
+	13, // 32: presence.PresenceService.GetOnlineUsers:output_type -> presence.OnlineUsersResponse
+	14, // 33: presence.PresenceService.GetTypingUsers:output_type -> presence.TypingUsersResponse
+	16, // 34: presence.PresenceService.GetUserConnections:output_type -> presence.ConnectionsResponse
+	19, // 35: presence.PresenceService.GetLastSeen:output_type -> presence.LastSeenResponse
+	21, // 36: presence.PresenceService.GetPresence:output_type -> presence.PresenceResponse
+	13, // 37: presence.PresenceService.JoinRoom:output_type -> presence.OnlineUsersResponse
+	24, // 38: presence.PresenceService.LeaveRoom:output_type -> presence.Empty
+	13, // 39: presence.PresenceService.GetRoomOnlineUsers:output_type -> presence.OnlineUsersResponse
+	14, // 40: presence.PresenceService.GetRoomTypingUsers:output_type -> presence.TypingUsersResponse
+	23, // 41: presence.PresenceService.WatchPresence:output_type -> presence.PresenceEvent
+	6,  // 42: presence.PresenceService.RegisterNode:output_type -> presence.NodeResponse
+	7,  // 43: presence.PresenceService.PurgeNode:output_type -> presence.PurgeNodeResponse
+	27, // [27:44] is the sub-list for method output_type
+	10, // [10:27] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_presence_proto_init() }
@@ -306,18 +1743,20 @@ func file_presence_proto_init() {
 	if File_presence_proto != nil {
 		return
 	}
+	file_presence_proto_msgTypes[20].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_presence_proto_rawDesc), len(file_presence_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   5,
+			NumEnums:      2,
+			NumMessages:   23,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_presence_proto_goTypes,
 		DependencyIndexes: file_presence_proto_depIdxs,
+		EnumInfos:         file_presence_proto_enumTypes,
 		MessageInfos:      file_presence_proto_msgTypes,
 	}.Build()
 	File_presence_proto = out.File
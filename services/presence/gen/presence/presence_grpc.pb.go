@@ -19,11 +19,23 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	PresenceService_UserConnected_FullMethodName    = "/presence.PresenceService/UserConnected"
-	PresenceService_UserDisconnected_FullMethodName = "/presence.PresenceService/UserDisconnected"
-	PresenceService_SetTyping_FullMethodName        = "/presence.PresenceService/SetTyping"
-	PresenceService_GetOnlineUsers_FullMethodName   = "/presence.PresenceService/GetOnlineUsers"
-	PresenceService_GetTypingUsers_FullMethodName   = "/presence.PresenceService/GetTypingUsers"
+	PresenceService_UserConnected_FullMethodName      = "/presence.PresenceService/UserConnected"
+	PresenceService_UserDisconnected_FullMethodName   = "/presence.PresenceService/UserDisconnected"
+	PresenceService_Heartbeat_FullMethodName          = "/presence.PresenceService/Heartbeat"
+	PresenceService_SetTyping_FullMethodName          = "/presence.PresenceService/SetTyping"
+	PresenceService_SetStatus_FullMethodName          = "/presence.PresenceService/SetStatus"
+	PresenceService_GetOnlineUsers_FullMethodName     = "/presence.PresenceService/GetOnlineUsers"
+	PresenceService_GetTypingUsers_FullMethodName     = "/presence.PresenceService/GetTypingUsers"
+	PresenceService_GetUserConnections_FullMethodName = "/presence.PresenceService/GetUserConnections"
+	PresenceService_GetLastSeen_FullMethodName        = "/presence.PresenceService/GetLastSeen"
+	PresenceService_GetPresence_FullMethodName        = "/presence.PresenceService/GetPresence"
+	PresenceService_JoinRoom_FullMethodName           = "/presence.PresenceService/JoinRoom"
+	PresenceService_LeaveRoom_FullMethodName          = "/presence.PresenceService/LeaveRoom"
+	PresenceService_GetRoomOnlineUsers_FullMethodName = "/presence.PresenceService/GetRoomOnlineUsers"
+	PresenceService_GetRoomTypingUsers_FullMethodName = "/presence.PresenceService/GetRoomTypingUsers"
+	PresenceService_WatchPresence_FullMethodName      = "/presence.PresenceService/WatchPresence"
+	PresenceService_RegisterNode_FullMethodName       = "/presence.PresenceService/RegisterNode"
+	PresenceService_PurgeNode_FullMethodName          = "/presence.PresenceService/PurgeNode"
 )
 
 // PresenceServiceClient is the client API for PresenceService service.
@@ -32,9 +44,28 @@ const (
 type PresenceServiceClient interface {
 	UserConnected(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error)
 	UserDisconnected(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*Empty, error)
+	// Heartbeat renews the lease of a connection registered with a connection_id.
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
 	SetTyping(ctx context.Context, in *SetTypingRequest, opts ...grpc.CallOption) (*Empty, error)
-	GetOnlineUsers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*OnlineUsersResponse, error)
+	SetStatus(ctx context.Context, in *SetStatusRequest, opts ...grpc.CallOption) (*UserStatus, error)
+	GetOnlineUsers(ctx context.Context, in *OnlineUsersRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error)
 	GetTypingUsers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TypingUsersResponse, error)
+	GetUserConnections(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*ConnectionsResponse, error)
+	GetLastSeen(ctx context.Context, in *UsersRequest, opts ...grpc.CallOption) (*LastSeenResponse, error)
+	// GetPresence looks up only the given users, e.g. a discussion's members.
+	GetPresence(ctx context.Context, in *UsersRequest, opts ...grpc.CallOption) (*PresenceResponse, error)
+	// Rooms are history-service discussion IDs.
+	JoinRoom(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error)
+	LeaveRoom(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetRoomOnlineUsers(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error)
+	GetRoomTypingUsers(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*TypingUsersResponse, error)
+	// WatchPresence sends a SNAPSHOT event followed by incremental changes.
+	WatchPresence(ctx context.Context, in *WatchPresenceRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PresenceEvent], error)
+	// RegisterNode registers a chat node or renews its lease. When a registered
+	// node's lease runs out, every connection tagged with it is dropped.
+	RegisterNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*NodeResponse, error)
+	// PurgeNode drops every connection tagged with a node, e.g. one that is gone.
+	PurgeNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*PurgeNodeResponse, error)
 }
 
 type presenceServiceClient struct {
@@ -65,6 +96,16 @@ func (c *presenceServiceClient) UserDisconnected(ctx context.Context, in *UserRe
 	return out, nil
 }
 
+func (c *presenceServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, PresenceService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *presenceServiceClient) SetTyping(ctx context.Context, in *SetTypingRequest, opts ...grpc.CallOption) (*Empty, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(Empty)
@@ -75,7 +116,17 @@ func (c *presenceServiceClient) SetTyping(ctx context.Context, in *SetTypingRequ
 	return out, nil
 }
 
-func (c *presenceServiceClient) GetOnlineUsers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*OnlineUsersResponse, error) {
+func (c *presenceServiceClient) SetStatus(ctx context.Context, in *SetStatusRequest, opts ...grpc.CallOption) (*UserStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UserStatus)
+	err := c.cc.Invoke(ctx, PresenceService_SetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetOnlineUsers(ctx context.Context, in *OnlineUsersRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(OnlineUsersResponse)
 	err := c.cc.Invoke(ctx, PresenceService_GetOnlineUsers_FullMethodName, in, out, cOpts...)
@@ -95,15 +146,143 @@ func (c *presenceServiceClient) GetTypingUsers(ctx context.Context, in *Empty, o
 	return out, nil
 }
 
+func (c *presenceServiceClient) GetUserConnections(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*ConnectionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConnectionsResponse)
+	err := c.cc.Invoke(ctx, PresenceService_GetUserConnections_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetLastSeen(ctx context.Context, in *UsersRequest, opts ...grpc.CallOption) (*LastSeenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LastSeenResponse)
+	err := c.cc.Invoke(ctx, PresenceService_GetLastSeen_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetPresence(ctx context.Context, in *UsersRequest, opts ...grpc.CallOption) (*PresenceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PresenceResponse)
+	err := c.cc.Invoke(ctx, PresenceService_GetPresence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) JoinRoom(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OnlineUsersResponse)
+	err := c.cc.Invoke(ctx, PresenceService_JoinRoom_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) LeaveRoom(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, PresenceService_LeaveRoom_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetRoomOnlineUsers(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*OnlineUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OnlineUsersResponse)
+	err := c.cc.Invoke(ctx, PresenceService_GetRoomOnlineUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) GetRoomTypingUsers(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*TypingUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TypingUsersResponse)
+	err := c.cc.Invoke(ctx, PresenceService_GetRoomTypingUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) WatchPresence(ctx context.Context, in *WatchPresenceRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PresenceEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PresenceService_ServiceDesc.Streams[0], PresenceService_WatchPresence_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchPresenceRequest, PresenceEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PresenceService_WatchPresenceClient = grpc.ServerStreamingClient[PresenceEvent]
+
+func (c *presenceServiceClient) RegisterNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*NodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NodeResponse)
+	err := c.cc.Invoke(ctx, PresenceService_RegisterNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *presenceServiceClient) PurgeNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*PurgeNodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PurgeNodeResponse)
+	err := c.cc.Invoke(ctx, PresenceService_PurgeNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PresenceServiceServer is the server API for PresenceService service.
 // All implementations must embed UnimplementedPresenceServiceServer
 // for forward compatibility.
 type PresenceServiceServer interface {
 	UserConnected(context.Context, *UserRequest) (*OnlineUsersResponse, error)
 	UserDisconnected(context.Context, *UserRequest) (*Empty, error)
+	// Heartbeat renews the lease of a connection registered with a connection_id.
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 	SetTyping(context.Context, *SetTypingRequest) (*Empty, error)
-	GetOnlineUsers(context.Context, *Empty) (*OnlineUsersResponse, error)
+	SetStatus(context.Context, *SetStatusRequest) (*UserStatus, error)
+	GetOnlineUsers(context.Context, *OnlineUsersRequest) (*OnlineUsersResponse, error)
 	GetTypingUsers(context.Context, *Empty) (*TypingUsersResponse, error)
+	GetUserConnections(context.Context, *UserRequest) (*ConnectionsResponse, error)
+	GetLastSeen(context.Context, *UsersRequest) (*LastSeenResponse, error)
+	// GetPresence looks up only the given users, e.g. a discussion's members.
+	GetPresence(context.Context, *UsersRequest) (*PresenceResponse, error)
+	// Rooms are history-service discussion IDs.
+	JoinRoom(context.Context, *UserRequest) (*OnlineUsersResponse, error)
+	LeaveRoom(context.Context, *UserRequest) (*Empty, error)
+	GetRoomOnlineUsers(context.Context, *RoomRequest) (*OnlineUsersResponse, error)
+	GetRoomTypingUsers(context.Context, *RoomRequest) (*TypingUsersResponse, error)
+	// WatchPresence sends a SNAPSHOT event followed by incremental changes.
+	WatchPresence(*WatchPresenceRequest, grpc.ServerStreamingServer[PresenceEvent]) error
+	// RegisterNode registers a chat node or renews its lease. When a registered
+	// node's lease runs out, every connection tagged with it is dropped.
+	RegisterNode(context.Context, *NodeRequest) (*NodeResponse, error)
+	// PurgeNode drops every connection tagged with a node, e.g. one that is gone.
+	PurgeNode(context.Context, *NodeRequest) (*PurgeNodeResponse, error)
 	mustEmbedUnimplementedPresenceServiceServer()
 }
 
@@ -120,15 +299,51 @@ func (UnimplementedPresenceServiceServer) UserConnected(context.Context, *UserRe
 func (UnimplementedPresenceServiceServer) UserDisconnected(context.Context, *UserRequest) (*Empty, error) {
 	return nil, status.Error(codes.Unimplemented, "method UserDisconnected not implemented")
 }
+func (UnimplementedPresenceServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Heartbeat not implemented")
+}
 func (UnimplementedPresenceServiceServer) SetTyping(context.Context, *SetTypingRequest) (*Empty, error) {
 	return nil, status.Error(codes.Unimplemented, "method SetTyping not implemented")
 }
-func (UnimplementedPresenceServiceServer) GetOnlineUsers(context.Context, *Empty) (*OnlineUsersResponse, error) {
+func (UnimplementedPresenceServiceServer) SetStatus(context.Context, *SetStatusRequest) (*UserStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method SetStatus not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetOnlineUsers(context.Context, *OnlineUsersRequest) (*OnlineUsersResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GetOnlineUsers not implemented")
 }
 func (UnimplementedPresenceServiceServer) GetTypingUsers(context.Context, *Empty) (*TypingUsersResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GetTypingUsers not implemented")
 }
+func (UnimplementedPresenceServiceServer) GetUserConnections(context.Context, *UserRequest) (*ConnectionsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetUserConnections not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetLastSeen(context.Context, *UsersRequest) (*LastSeenResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetLastSeen not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetPresence(context.Context, *UsersRequest) (*PresenceResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPresence not implemented")
+}
+func (UnimplementedPresenceServiceServer) JoinRoom(context.Context, *UserRequest) (*OnlineUsersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method JoinRoom not implemented")
+}
+func (UnimplementedPresenceServiceServer) LeaveRoom(context.Context, *UserRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method LeaveRoom not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetRoomOnlineUsers(context.Context, *RoomRequest) (*OnlineUsersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRoomOnlineUsers not implemented")
+}
+func (UnimplementedPresenceServiceServer) GetRoomTypingUsers(context.Context, *RoomRequest) (*TypingUsersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRoomTypingUsers not implemented")
+}
+func (UnimplementedPresenceServiceServer) WatchPresence(*WatchPresenceRequest, grpc.ServerStreamingServer[PresenceEvent]) error {
+	return status.Error(codes.Unimplemented, "method WatchPresence not implemented")
+}
+func (UnimplementedPresenceServiceServer) RegisterNode(context.Context, *NodeRequest) (*NodeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RegisterNode not implemented")
+}
+func (UnimplementedPresenceServiceServer) PurgeNode(context.Context, *NodeRequest) (*PurgeNodeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method PurgeNode not implemented")
+}
 func (UnimplementedPresenceServiceServer) mustEmbedUnimplementedPresenceServiceServer() {}
 func (UnimplementedPresenceServiceServer) testEmbeddedByValue()                         {}
 
@@ -186,6 +401,24 @@ func _PresenceService_UserDisconnected_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PresenceService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _PresenceService_SetTyping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SetTypingRequest)
 	if err := dec(in); err != nil {
@@ -204,8 +437,26 @@ func _PresenceService_SetTyping_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PresenceService_SetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).SetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_SetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).SetStatus(ctx, req.(*SetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _PresenceService_GetOnlineUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Empty)
+	in := new(OnlineUsersRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -217,7 +468,7 @@ func _PresenceService_GetOnlineUsers_Handler(srv interface{}, ctx context.Contex
 		FullMethod: PresenceService_GetOnlineUsers_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(PresenceServiceServer).GetOnlineUsers(ctx, req.(*Empty))
+		return srv.(PresenceServiceServer).GetOnlineUsers(ctx, req.(*OnlineUsersRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -240,6 +491,179 @@ func _PresenceService_GetTypingUsers_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PresenceService_GetUserConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetUserConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_GetUserConnections_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetUserConnections(ctx, req.(*UserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_GetLastSeen_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetLastSeen(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_GetLastSeen_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetLastSeen(ctx, req.(*UsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_GetPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_GetPresence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetPresence(ctx, req.(*UsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_JoinRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).JoinRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_JoinRoom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).JoinRoom(ctx, req.(*UserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_LeaveRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).LeaveRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_LeaveRoom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).LeaveRoom(ctx, req.(*UserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_GetRoomOnlineUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetRoomOnlineUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_GetRoomOnlineUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetRoomOnlineUsers(ctx, req.(*RoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_GetRoomTypingUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).GetRoomTypingUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_GetRoomTypingUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).GetRoomTypingUsers(ctx, req.(*RoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_WatchPresence_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPresenceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PresenceServiceServer).WatchPresence(m, &grpc.GenericServerStream[WatchPresenceRequest, PresenceEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PresenceService_WatchPresenceServer = grpc.ServerStreamingServer[PresenceEvent]
+
+func _PresenceService_RegisterNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).RegisterNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_RegisterNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).RegisterNode(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PresenceService_PurgeNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).PurgeNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_PurgeNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).PurgeNode(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PresenceService_ServiceDesc is the grpc.ServiceDesc for PresenceService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -255,10 +679,18 @@ var PresenceService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UserDisconnected",
 			Handler:    _PresenceService_UserDisconnected_Handler,
 		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _PresenceService_Heartbeat_Handler,
+		},
 		{
 			MethodName: "SetTyping",
 			Handler:    _PresenceService_SetTyping_Handler,
 		},
+		{
+			MethodName: "SetStatus",
+			Handler:    _PresenceService_SetStatus_Handler,
+		},
 		{
 			MethodName: "GetOnlineUsers",
 			Handler:    _PresenceService_GetOnlineUsers_Handler,
@@ -267,7 +699,49 @@ var PresenceService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetTypingUsers",
 			Handler:    _PresenceService_GetTypingUsers_Handler,
 		},
+		{
+			MethodName: "GetUserConnections",
+			Handler:    _PresenceService_GetUserConnections_Handler,
+		},
+		{
+			MethodName: "GetLastSeen",
+			Handler:    _PresenceService_GetLastSeen_Handler,
+		},
+		{
+			MethodName: "GetPresence",
+			Handler:    _PresenceService_GetPresence_Handler,
+		},
+		{
+			MethodName: "JoinRoom",
+			Handler:    _PresenceService_JoinRoom_Handler,
+		},
+		{
+			MethodName: "LeaveRoom",
+			Handler:    _PresenceService_LeaveRoom_Handler,
+		},
+		{
+			MethodName: "GetRoomOnlineUsers",
+			Handler:    _PresenceService_GetRoomOnlineUsers_Handler,
+		},
+		{
+			MethodName: "GetRoomTypingUsers",
+			Handler:    _PresenceService_GetRoomTypingUsers_Handler,
+		},
+		{
+			MethodName: "RegisterNode",
+			Handler:    _PresenceService_RegisterNode_Handler,
+		},
+		{
+			MethodName: "PurgeNode",
+			Handler:    _PresenceService_PurgeNode_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPresence",
+			Handler:       _PresenceService_WatchPresence_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "presence.proto",
 }